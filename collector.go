@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+// Collector exports one group of pgpool metrics.
+type Collector interface {
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+	Describe(ch chan<- *prometheus.Desc)
+}
+
+// ErrNoData is returned by collectors which have nothing to export, e.g.
+// because the pgpool release lacks the feature they rely on.
+var ErrNoData = errors.New("collector returned no data")
+
+type collectorFactory func(pgpool pgpool2.Source) (Collector, error)
+
+type collectorFlags struct {
+	enable  *bool
+	disable *bool
+}
+
+var (
+	collectorFactories = make(map[string]collectorFactory)
+	collectorState     = make(map[string]collectorFlags)
+)
+
+// registerCollector makes a collector available under name together with
+// its --collector.<name> and --no-collector.<name> flags.
+func registerCollector(name string, enabledByDefault bool, factory collectorFactory) {
+	defaultState := "disabled"
+	if enabledByDefault {
+		defaultState = "enabled"
+	}
+	collectorState[name] = collectorFlags{
+		enable:  flag.Bool("collector."+name, enabledByDefault, fmt.Sprintf("Enable the %s collector (default: %s)", name, defaultState)),
+		disable: flag.Bool("no-collector."+name, false, fmt.Sprintf("Disable the %s collector", name)),
+	}
+	collectorFactories[name] = factory
+}
+
+// enabledCollectors returns the sorted names of the collectors enabled by
+// flags.
+func enabledCollectors() []string {
+	var names []string
+	for name, state := range collectorState {
+		if *state.enable && !*state.disable {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// emitStateSet sends one series per state in the style of an OpenMetrics
+// StateSet: 1 for the current state and 0 for all others. The state is
+// the last label of desc.
+func emitStateSet(ch chan<- prometheus.Metric, desc *prometheus.Desc, states []string, current string, labels ...string) {
+	for _, state := range states {
+		value := 0.0
+		if state == current {
+			value = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			desc,
+			prometheus.GaugeValue,
+			value,
+			append(labels, state)...,
+		)
+	}
+}
+
+// requireFeature returns ErrNoData if pgpool is known to lack feature.
+func requireFeature(ctx context.Context, pgpool pgpool2.Source, feature string) error {
+	// an unknown version supports everything
+	version, _ := pgpool.ServerVersion(ctx)
+	if !version.Supports(feature) {
+		return fmt.Errorf("%w: pgpool %s does not support %s", ErrNoData, version, feature)
+	}
+	return nil
+}
+
+// observedSource reports the error of every PCP command it runs.
+type observedSource struct {
+	pgpool2.Source
+	observe func(err error)
+}
+
+// newObservedSource wraps source, whose version detection is observed
+// by source itself since ServerVersion returns cached results.
+func newObservedSource(source pgpool2.Source, observe func(err error)) *observedSource {
+	if detector, ok := source.(pgpool2.VersionDetector); ok {
+		detector.ObserveVersionDetection(observe)
+	}
+	return &observedSource{
+		Source:  source,
+		observe: observe,
+	}
+}
+
+func (s *observedSource) Ping(ctx context.Context) error {
+	err := s.Source.Ping(ctx)
+	s.observe(err)
+	return err
+}
+
+func (s *observedSource) ExecNodeCount(ctx context.Context) (int, error) {
+	nodeCount, err := s.Source.ExecNodeCount(ctx)
+	s.observe(err)
+	return nodeCount, err
+}
+
+func (s *observedSource) ExecNodeInfo(ctx context.Context, nodeID int) (pgpool2.NodeInfo, error) {
+	nodeInfo, err := s.Source.ExecNodeInfo(ctx, nodeID)
+	s.observe(err)
+	return nodeInfo, err
+}
+
+func (s *observedSource) ExecAllNodeInfo(ctx context.Context) ([]pgpool2.NodeInfo, error) {
+	nodes, err := s.Source.ExecAllNodeInfo(ctx)
+	s.observe(err)
+	return nodes, err
+}
+
+func (s *observedSource) ExecProcCount(ctx context.Context) ([]string, error) {
+	procArr, err := s.Source.ExecProcCount(ctx)
+	s.observe(err)
+	return procArr, err
+}
+
+func (s *observedSource) ExecProcInfo(ctx context.Context) ([]pgpool2.ProcInfo, error) {
+	procInfoArr, err := s.Source.ExecProcInfo(ctx)
+	s.observe(err)
+	return procInfoArr, err
+}
+
+func (s *observedSource) ExecWatchdogInfo(ctx context.Context) (pgpool2.WatchdogInfo, error) {
+	watchdogInfo, err := s.Source.ExecWatchdogInfo(ctx)
+	s.observe(err)
+	return watchdogInfo, err
+}
+
+func (s *observedSource) ExecHealthCheckStats(ctx context.Context, nodeID int) (pgpool2.HealthCheckStats, error) {
+	stats, err := s.Source.ExecHealthCheckStats(ctx, nodeID)
+	s.observe(err)
+	return stats, err
+}
+
+func (s *observedSource) ExecPoolNodes(ctx context.Context) ([]pgpool2.PoolNode, error) {
+	nodes, err := s.Source.ExecPoolNodes(ctx)
+	s.observe(err)
+	return nodes, err
+}
+
+func (s *observedSource) ExecPoolCache(ctx context.Context) (pgpool2.PoolCache, error) {
+	cache, err := s.Source.ExecPoolCache(ctx)
+	s.observe(err)
+	return cache, err
+}
+
+func (s *observedSource) ExecBackendStats(ctx context.Context) ([]pgpool2.BackendStats, error) {
+	stats, err := s.Source.ExecBackendStats(ctx)
+	s.observe(err)
+	return stats, err
+}
+
+func (s *observedSource) Peer(address string) (pgpool2.Source, error) {
+	peer, err := s.Source.Peer(address)
+	if err != nil {
+		return nil, err
+	}
+	return newObservedSource(peer, s.observe), nil
+}
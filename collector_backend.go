@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+// backendLabels identify a backend node in the metrics of SHOW commands
+var backendLabels = []string{"node_id", "hostname"}
+
+var (
+	BackendSelectTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "select_total"),
+		"Number of SELECTs load balanced to node, carried over pgpool restarts",
+		backendLabels, nil,
+	)
+	BackendLoadBalanceNode = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "load_balance_node"),
+		"Whether node is the load balance node of the session of the exporter (1 for yes, 0 for no)",
+		backendLabels, nil,
+	)
+)
+
+// backendCollector exports the load balancing counters of SHOW POOL_NODES,
+// which needs --pgpool.dsn.
+type backendCollector struct {
+	pgpool pgpool2.Source
+
+	// mu guards the counters tracked across scrapes
+	mu       sync.Mutex
+	counters map[backendKey]*resetCounter
+}
+
+type backendKey struct {
+	nodeID   int
+	hostname string
+}
+
+// resetCounter turns a value which drops to zero when pgpool restarts
+// into a monotonic counter.
+type resetCounter struct {
+	last   int64
+	offset int64
+}
+
+// update returns the counter for the reported value. A value below the
+// previous one means pgpool restarted, its count up to then is kept.
+func (r *resetCounter) update(value int64) int64 {
+	if value < r.last {
+		r.offset += r.last
+	}
+	r.last = value
+	return r.offset + value
+}
+
+func init() {
+	registerCollector("backend", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &backendCollector{
+			pgpool:   pgpool,
+			counters: make(map[backendKey]*resetCounter),
+		}, nil
+	})
+}
+
+// selectTotal returns the monotonic select counter of node.
+func (c *backendCollector) selectTotal(node pgpool2.PoolNode) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := backendKey{nodeID: node.NodeID, hostname: node.Hostname}
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &resetCounter{}
+		c.counters[key] = counter
+	}
+	return counter.update(node.SelectCount)
+}
+
+// prune forgets the counters of the nodes no longer reported, so that
+// removed nodes do not pile up across scrapes.
+func (c *backendCollector) prune(nodes []pgpool2.PoolNode) {
+	reported := make(map[backendKey]bool, len(nodes))
+	for _, node := range nodes {
+		reported[backendKey{nodeID: node.NodeID, hostname: node.Hostname}] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.counters {
+		if !reported[key] {
+			delete(c.counters, key)
+		}
+	}
+}
+
+func (c *backendCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	nodes, err := c.pgpool.ExecPoolNodes(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecPoolNodes() error: %w", err)
+	}
+	for _, node := range nodes {
+		labels := []string{strconv.Itoa(node.NodeID), node.Hostname}
+		ch <- prometheus.MustNewConstMetric(
+			BackendSelectTotal,
+			prometheus.CounterValue,
+			float64(c.selectTotal(node)),
+			labels...,
+		)
+		loadBalanceNode := 0.0
+		if node.LoadBalanceNode {
+			loadBalanceNode = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			BackendLoadBalanceNode,
+			prometheus.GaugeValue,
+			loadBalanceNode,
+			labels...,
+		)
+	}
+	c.prune(nodes)
+	return nil
+}
+
+func (c *backendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- BackendSelectTotal
+	ch <- BackendLoadBalanceNode
+}
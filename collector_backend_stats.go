@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	BackendStatementsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "statements_total"),
+		"Statements sent to node by type (select, insert, update, delete, ddl, other), carried over pgpool restarts",
+		[]string{"node_id", "type"}, nil,
+	)
+	BackendErrorsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "backend", "errors_total"),
+		"Error messages returned by node by severity (panic, fatal, error), carried over pgpool restarts",
+		[]string{"node_id", "severity"}, nil,
+	)
+)
+
+// backendStatsCollector exports the statements and errors of every node
+// from SHOW POOL_BACKEND_STATS, which needs --pgpool.dsn.
+type backendStatsCollector struct {
+	pgpool pgpool2.Source
+
+	// mu guards the counters tracked across scrapes
+	mu       sync.Mutex
+	counters map[backendStatsKey]*resetCounter
+}
+
+type backendStatsKey struct {
+	desc   *prometheus.Desc
+	nodeID int
+	kind   string
+}
+
+func init() {
+	registerCollector("backend_stats", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &backendStatsCollector{
+			pgpool:   pgpool,
+			counters: make(map[backendStatsKey]*resetCounter),
+		}, nil
+	})
+}
+
+// emit sends the monotonic counter for value, labelled by node id and kind.
+func (c *backendStatsCollector) emit(ch chan<- prometheus.Metric, desc *prometheus.Desc, nodeID int, kind string, value int64) {
+	c.mu.Lock()
+	key := backendStatsKey{desc: desc, nodeID: nodeID, kind: kind}
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &resetCounter{}
+		c.counters[key] = counter
+	}
+	total := counter.update(value)
+	c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(
+		desc,
+		prometheus.CounterValue,
+		float64(total),
+		strconv.Itoa(nodeID),
+		kind,
+	)
+}
+
+// prune forgets the counters of the nodes no longer reported, so that
+// removed nodes do not pile up across scrapes.
+func (c *backendStatsCollector) prune(stats []pgpool2.BackendStats) {
+	reported := make(map[int]bool, len(stats))
+	for _, bs := range stats {
+		reported[bs.NodeID] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.counters {
+		if !reported[key.nodeID] {
+			delete(c.counters, key)
+		}
+	}
+}
+
+func (c *backendStatsCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if err := requireFeature(ctx, c.pgpool, pgpool2.FeatureBackendStats); err != nil {
+		return err
+	}
+	stats, err := c.pgpool.ExecBackendStats(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecBackendStats() error: %w", err)
+	}
+	for _, bs := range stats {
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "select", bs.SelectCount)
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "insert", bs.InsertCount)
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "update", bs.UpdateCount)
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "delete", bs.DeleteCount)
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "ddl", bs.DDLCount)
+		c.emit(ch, BackendStatementsTotal, bs.NodeID, "other", bs.OtherCount)
+		if !bs.ErrorCounts {
+			continue
+		}
+		c.emit(ch, BackendErrorsTotal, bs.NodeID, "panic", bs.PanicCount)
+		c.emit(ch, BackendErrorsTotal, bs.NodeID, "fatal", bs.FatalCount)
+		c.emit(ch, BackendErrorsTotal, bs.NodeID, "error", bs.ErrorCount)
+	}
+	c.prune(stats)
+	return nil
+}
+
+func (c *backendStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- BackendStatementsTotal
+	ch <- BackendErrorsTotal
+}
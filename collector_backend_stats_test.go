@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+// counterValue returns the value of the metric of desc labelled with
+// labels, which must be exported exactly once.
+func counterValue(t *testing.T, metrics []prometheus.Metric, desc *prometheus.Desc, labels map[string]string) float64 {
+	t.Helper()
+	var values []float64
+	for _, metric := range metrics {
+		if metric.Desc() != desc {
+			continue
+		}
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		matches := 0
+		for _, label := range m.GetLabel() {
+			if labels[label.GetName()] == label.GetValue() {
+				matches++
+			}
+		}
+		if matches == len(labels) {
+			values = append(values, m.GetCounter().GetValue())
+		}
+	}
+	if len(values) != 1 {
+		t.Fatalf("got %d metrics %s%v, want 1", len(values), desc, labels)
+	}
+	return values[0]
+}
+
+func TestBackendStatsCollectorRestart(t *testing.T) {
+	source := healthySource()
+	source.Version = pgpool2.Version{Major: 4, Minor: 4, Patch: 2}
+	source.Backends = []pgpool2.BackendStats{
+		{NodeID: 0, Hostname: "pg0", SelectCount: 100, ErrorCounts: true, ErrorCount: 4},
+		{NodeID: 1, Hostname: "pg1", SelectCount: 50, ErrorCounts: true},
+	}
+	c := &backendStatsCollector{pgpool: source, counters: make(map[backendStatsKey]*resetCounter)}
+	collect(t, c)
+
+	// pgpool restarted and counted again before the scrape
+	source.Backends[0].SelectCount = 30
+	source.Backends[0].ErrorCount = 1
+	metrics := collect(t, c)
+	selects := counterValue(t, metrics, BackendStatementsTotal, map[string]string{"node_id": "0", "type": "select"})
+	if selects != 130 {
+		t.Errorf("got %v selects after the restart, want 130", selects)
+	}
+	failures := counterValue(t, metrics, BackendErrorsTotal, map[string]string{"node_id": "0", "severity": "error"})
+	if failures != 5 {
+		t.Errorf("got %v errors after the restart, want 5", failures)
+	}
+
+	source.Backends = source.Backends[:1]
+	collect(t, c)
+	for key := range c.counters {
+		if key.nodeID != 0 {
+			t.Errorf("counter %+v of the removed node 1 was kept", key)
+		}
+	}
+}
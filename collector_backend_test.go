@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+func TestResetCounterUpdate(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []int64
+		want   []int64
+	}{
+		{
+			name:   "increase",
+			values: []int64{5, 8, 8, 20},
+			want:   []int64{5, 8, 8, 20},
+		},
+		{
+			// pgpool restarted and reported nothing yet
+			name:   "drop to zero",
+			values: []int64{5, 8, 0, 3},
+			want:   []int64{5, 8, 8, 11},
+		},
+		{
+			// pgpool restarted and counted again before the scrape
+			name:   "partial drop",
+			values: []int64{5, 8, 2, 6, 1},
+			want:   []int64{5, 8, 10, 14, 15},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var counter resetCounter
+			for i, value := range tt.values {
+				if got := counter.update(value); got != tt.want[i] {
+					t.Errorf("update(%d) at scrape %d = %d, want %d", value, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// collect runs one Update of c and returns the metrics.
+func collect(t *testing.T, c Collector) []prometheus.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Update(context.Background(), ch)
+		close(ch)
+	}()
+	var metrics []prometheus.Metric
+	for metric := range ch {
+		metrics = append(metrics, metric)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func TestBackendCollectorPrunesRemovedNodes(t *testing.T) {
+	source := healthySource()
+	source.PoolNodes = []pgpool2.PoolNode{
+		{NodeID: 0, Hostname: "pg0", SelectCount: 10},
+		{NodeID: 1, Hostname: "pg1", SelectCount: 20},
+	}
+	c := &backendCollector{pgpool: source, counters: make(map[backendKey]*resetCounter)}
+	collect(t, c)
+	source.PoolNodes = source.PoolNodes[:1]
+	collect(t, c)
+	if len(c.counters) != 1 {
+		t.Errorf("got counters %v, want only node 0", c.counters)
+	}
+	if _, ok := c.counters[backendKey{nodeID: 0, hostname: "pg0"}]; !ok {
+		t.Error("the counter of node 0 was dropped")
+	}
+}
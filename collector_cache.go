@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	CacheHitsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "hits_total"),
+		"Number of SELECTs answered from the query cache, carried over pgpool restarts",
+		nil, nil,
+	)
+	CacheSelectsTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "selects_total"),
+		"Number of SELECTs eligible for the query cache, carried over pgpool restarts",
+		nil, nil,
+	)
+	CacheHitRatio = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "hit_ratio"),
+		"Share of SELECTs answered from the query cache since pgpool started",
+		nil, nil,
+	)
+	CacheHashEntries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "hash_entries"),
+		"Number of entries of the query cache hash table",
+		nil, nil,
+	)
+	CacheUsedHashEntries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "used_hash_entries"),
+		"Number of used entries of the query cache hash table",
+		nil, nil,
+	)
+	CacheEntries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "entries"),
+		"Number of cached queries",
+		nil, nil,
+	)
+	CacheUsedBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "used_bytes"),
+		"Size of the cached queries in bytes",
+		nil, nil,
+	)
+	CacheFreeBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "free_bytes"),
+		"Free space of the query cache in bytes",
+		nil, nil,
+	)
+	CacheFragmentBytes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "cache", "fragment_bytes"),
+		"Fragmented space of the query cache in bytes",
+		nil, nil,
+	)
+)
+
+// cacheCollector exports the query cache state from SHOW POOL_CACHE,
+// which needs --pgpool.dsn.
+type cacheCollector struct {
+	pgpool pgpool2.Source
+
+	// mu guards the counters tracked across scrapes
+	mu      sync.Mutex
+	hits    resetCounter
+	selects resetCounter
+}
+
+func init() {
+	registerCollector("cache", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &cacheCollector{pgpool: pgpool}, nil
+	})
+}
+
+func (c *cacheCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	cache, err := c.pgpool.ExecPoolCache(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecPoolCache() error: %w", err)
+	}
+	c.mu.Lock()
+	hits := c.hits.update(cache.CacheHits)
+	selects := c.selects.update(cache.Selects)
+	c.mu.Unlock()
+	ch <- prometheus.MustNewConstMetric(CacheHitsTotal, prometheus.CounterValue, float64(hits))
+	ch <- prometheus.MustNewConstMetric(CacheSelectsTotal, prometheus.CounterValue, float64(selects))
+	ch <- prometheus.MustNewConstMetric(CacheHitRatio, prometheus.GaugeValue, cache.CacheHitRatio)
+	ch <- prometheus.MustNewConstMetric(CacheHashEntries, prometheus.GaugeValue, float64(cache.HashEntries))
+	ch <- prometheus.MustNewConstMetric(CacheUsedHashEntries, prometheus.GaugeValue, float64(cache.UsedHashEntries))
+	ch <- prometheus.MustNewConstMetric(CacheEntries, prometheus.GaugeValue, float64(cache.CacheEntries))
+	ch <- prometheus.MustNewConstMetric(CacheUsedBytes, prometheus.GaugeValue, float64(cache.UsedCacheEntriesSize))
+	ch <- prometheus.MustNewConstMetric(CacheFreeBytes, prometheus.GaugeValue, float64(cache.FreeCacheEntriesSize))
+	ch <- prometheus.MustNewConstMetric(CacheFragmentBytes, prometheus.GaugeValue, float64(cache.FragmentCacheEntriesSize))
+	return nil
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- CacheHitsTotal
+	ch <- CacheSelectsTotal
+	ch <- CacheHitRatio
+	ch <- CacheHashEntries
+	ch <- CacheUsedHashEntries
+	ch <- CacheEntries
+	ch <- CacheUsedBytes
+	ch <- CacheFreeBytes
+	ch <- CacheFragmentBytes
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	HealthCheckTotal = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "total"),
+		"Number of health checks of node",
+		nodeLabels, nil,
+	)
+	HealthCheckSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "success_total"),
+		"Number of successful health checks of node",
+		nodeLabels, nil,
+	)
+	HealthCheckFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "failures_total"),
+		"Number of failed health checks of node",
+		nodeLabels, nil,
+	)
+	HealthCheckSkips = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "skips_total"),
+		"Number of skipped health checks of node",
+		nodeLabels, nil,
+	)
+	HealthCheckRetries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "retries_total"),
+		"Number of health check retries of node",
+		nodeLabels, nil,
+	)
+	HealthCheckAverageRetries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "average_retries"),
+		"Average number of retries of a health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckMaxRetries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "max_retries"),
+		"Maximum number of retries of a health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckDurationMax = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "duration_max_seconds"),
+		"Longest health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckDurationMin = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "duration_min_seconds"),
+		"Shortest health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckDurationAverage = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "duration_average_seconds"),
+		"Average duration of a health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckLast = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "last_timestamp_seconds"),
+		"Time of the last health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckLastSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "last_success_timestamp_seconds"),
+		"Time of the last successful health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckLastSkip = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "last_skip_timestamp_seconds"),
+		"Time of the last skipped health check of node",
+		nodeLabels, nil,
+	)
+	HealthCheckLastFailure = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "health_check", "last_failure_timestamp_seconds"),
+		"Time of the last failed health check of node",
+		nodeLabels, nil,
+	)
+)
+
+// healthCheckCollector exports the health check statistics of every node
+// from pcp_health_check_stats.
+type healthCheckCollector struct {
+	pgpool pgpool2.Source
+}
+
+func init() {
+	registerCollector("health_check", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &healthCheckCollector{pgpool: pgpool}, nil
+	})
+}
+
+func (c *healthCheckCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if err := requireFeature(ctx, c.pgpool, pgpool2.FeatureHealthCheckStats); err != nil {
+		return err
+	}
+	nodeCount, err := c.pgpool.ExecNodeCount(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecNodeCount() error: %w", err)
+	}
+	// a failing node must not hide the metrics of the others
+	var failed []string
+	var firstErr error
+	for nodeID := 0; nodeID < nodeCount; nodeID++ {
+		stats, err := c.pgpool.ExecHealthCheckStats(ctx, nodeID)
+		if err != nil {
+			failed = append(failed, strconv.Itoa(nodeID))
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		c.emitHealthCheckStats(ch, nodeID, stats)
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("ExecHealthCheckStats() error for node ids %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+func (c *healthCheckCollector) emitHealthCheckStats(ch chan<- prometheus.Metric, id int, stats pgpool2.HealthCheckStats) {
+	labels := []string{
+		strconv.Itoa(id),
+		stats.Hostname,
+		strconv.Itoa(stats.Port),
+	}
+	counters := []struct {
+		desc  *prometheus.Desc
+		value int64
+	}{
+		{HealthCheckTotal, stats.TotalCount},
+		{HealthCheckSuccess, stats.SuccessCount},
+		{HealthCheckFailures, stats.FailCount},
+		{HealthCheckSkips, stats.SkipCount},
+		{HealthCheckRetries, stats.RetryCount},
+	}
+	for _, counter := range counters {
+		ch <- prometheus.MustNewConstMetric(
+			counter.desc,
+			prometheus.CounterValue,
+			float64(counter.value),
+			labels...,
+		)
+	}
+	gauges := []struct {
+		desc  *prometheus.Desc
+		value float64
+	}{
+		{HealthCheckAverageRetries, stats.AverageRetryCount},
+		{HealthCheckMaxRetries, float64(stats.MaxRetryCount)},
+		{HealthCheckDurationMax, stats.MaxDuration.Seconds()},
+		{HealthCheckDurationMin, stats.MinDuration.Seconds()},
+		{HealthCheckDurationAverage, stats.AverageDuration.Seconds()},
+	}
+	for _, gauge := range gauges {
+		ch <- prometheus.MustNewConstMetric(
+			gauge.desc,
+			prometheus.GaugeValue,
+			gauge.value,
+			labels...,
+		)
+	}
+	// events which never happened are not exported
+	timestamps := []struct {
+		desc  *prometheus.Desc
+		value time.Time
+	}{
+		{HealthCheckLast, stats.LastHealthCheck},
+		{HealthCheckLastSuccess, stats.LastSuccessfulHealthCheck},
+		{HealthCheckLastSkip, stats.LastSkipHealthCheck},
+		{HealthCheckLastFailure, stats.LastFailedHealthCheck},
+	}
+	for _, timestamp := range timestamps {
+		if timestamp.value.IsZero() {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(
+			timestamp.desc,
+			prometheus.GaugeValue,
+			float64(timestamp.value.Unix()),
+			labels...,
+		)
+	}
+}
+
+func (c *healthCheckCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- HealthCheckTotal
+	ch <- HealthCheckSuccess
+	ch <- HealthCheckFailures
+	ch <- HealthCheckSkips
+	ch <- HealthCheckRetries
+	ch <- HealthCheckAverageRetries
+	ch <- HealthCheckMaxRetries
+	ch <- HealthCheckDurationMax
+	ch <- HealthCheckDurationMin
+	ch <- HealthCheckDurationAverage
+	ch <- HealthCheckLast
+	ch <- HealthCheckLastSuccess
+	ch <- HealthCheckLastSkip
+	ch <- HealthCheckLastFailure
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+// nodeLabels identify a backend node across status and role changes
+var nodeLabels = []string{"id", "hostname", "port"}
+
+var (
+	PoolNodeCount = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_count"),
+		"Displays the total number of database nodes",
+		nil, nil,
+	)
+	PoolNodeInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "node_info"),
+		"Displays the information of node",
+		[]string{"id", "node", "port", "width", "role", "replicationDelay", "replicationState", "replicationSyncState", "lastStatusChange"}, nil,
+	)
+	PoolNodeStatusCode = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "status_code"),
+		"Status code of node (0 initialization, 1 up without connections, 2 up, 3 down)",
+		nodeLabels, nil,
+	)
+	PoolNodeStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "status"),
+		"Whether node is in the state of the state label (1 for the current state)",
+		append(nodeLabels, "state"), nil,
+	)
+	PoolNodeRole = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "role"),
+		"Whether node has the role of the role label (1 for the current role)",
+		append(nodeLabels, "role"), nil,
+	)
+	PoolNodeWeight = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "weight"),
+		"Load balance weight of node",
+		nodeLabels, nil,
+	)
+	PoolNodeReplicationDelay = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "replication_delay"),
+		"Replication delay of node as reported by pgpool",
+		nodeLabels, nil,
+	)
+	PoolNodeLastStatusChange = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "last_status_change_timestamp_seconds"),
+		"Time of the last status change of node",
+		nodeLabels, nil,
+	)
+	PoolNodeTimeInState = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "time_in_state_seconds"),
+		"Seconds since node entered its current state",
+		append(nodeLabels, "state"), nil,
+	)
+	PoolNodeScrapeError = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "node", "scrape_error"),
+		"Whether retrieving the information of node failed (1 for error, 0 for success)",
+		[]string{"id"}, nil,
+	)
+)
+
+var nodeLegacyInfo = flag.Bool("collector.node.legacy-info", false, "Also export pgpool2_node_info, which carries weight, delay and last status change as labels")
+
+var nodeInfoWorkers = flag.Int("collector.node.workers", 4, "Maximum number of concurrent pcp_node_info calls (the native backend runs them one by one on its session)")
+
+// nodeCollector exports the backend nodes from pcp_node_count and pcp_node_info.
+type nodeCollector struct {
+	pgpool     pgpool2.Source
+	legacyInfo bool
+	// perNode is set to 1 once fetching all nodes at once failed on a
+	// pgpool of unknown version
+	perNode int32
+}
+
+func init() {
+	registerCollector("node", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &nodeCollector{
+			pgpool:     pgpool,
+			legacyInfo: *nodeLegacyInfo,
+		}, nil
+	})
+}
+
+type nodeInfoResult struct {
+	nodeInfo pgpool2.NodeInfo
+	err      error
+}
+
+// fetchNodeInfo runs ExecNodeInfo for every node on a bounded pool of
+// workers; the results are indexed by node id.
+func (c *nodeCollector) fetchNodeInfo(ctx context.Context, nodeCount int) []nodeInfoResult {
+	results := make([]nodeInfoResult, nodeCount)
+	workers := *nodeInfoWorkers
+	if workers > nodeCount {
+		workers = nodeCount
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	nodeIDs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for nodeID := range nodeIDs {
+				results[nodeID].nodeInfo, results[nodeID].err = c.pgpool.ExecNodeInfo(ctx, nodeID)
+			}
+		}()
+	}
+	for nodeID := 0; nodeID < nodeCount; nodeID++ {
+		nodeIDs <- nodeID
+	}
+	close(nodeIDs)
+	wg.Wait()
+	return results
+}
+
+// fetchAllNodeInfo returns every node from a single pcp_node_info call if
+// pgpool supports it, and from pcp_node_count plus one pcp_node_info call
+// per node otherwise or if the single call fails.
+func (c *nodeCollector) fetchAllNodeInfo(ctx context.Context) ([]nodeInfoResult, error) {
+	version, _ := c.pgpool.ServerVersion(ctx)
+	if version.Supports(pgpool2.FeatureAllNodeInfo) && atomic.LoadInt32(&c.perNode) == 0 {
+		nodes, err := c.pgpool.ExecAllNodeInfo(ctx)
+		if err == nil {
+			results := make([]nodeInfoResult, len(nodes))
+			for i := range nodes {
+				results[i].nodeInfo = nodes[i]
+			}
+			return results, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("ExecAllNodeInfo() error: %w", err)
+		}
+		if version.IsZero() && !errors.Is(err, pgpool2.ErrTimeout) {
+			// the release is unknown and apparently older than 4.1
+			logrus.Infof("Fetching all nodes at once failed, falling back to one pcp_node_info call per node: %v", err)
+			atomic.StoreInt32(&c.perNode, 1)
+		} else {
+			// the release supports it, so only this scrape falls back
+			// and the failing nodes show up in node_scrape_error
+			logrus.Warnf("Fetching all nodes at once failed, trying one pcp_node_info call per node: %v", err)
+		}
+	}
+	nodeCount, err := c.pgpool.ExecNodeCount(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ExecNodeCount() error: %w", err)
+	}
+	return c.fetchNodeInfo(ctx, nodeCount), nil
+}
+
+func (c *nodeCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	results, err := c.fetchAllNodeInfo(ctx)
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolNodeCount,
+		prometheus.GaugeValue,
+		float64(len(results)),
+	)
+	// a failing node must not hide the metrics of the others
+	var failed []string
+	var firstErr error
+	for i, result := range results {
+		nodeInfo, err := result.nodeInfo, result.err
+		scrapeError := 0.0
+		if err != nil {
+			scrapeError = 1.0
+			failed = append(failed, strconv.Itoa(i))
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(
+			PoolNodeScrapeError,
+			prometheus.GaugeValue,
+			scrapeError,
+			strconv.Itoa(i),
+		)
+		if err != nil {
+			continue
+		}
+		c.emitNodeInfo(ch, i, nodeInfo)
+	}
+	if len(failed) != 0 {
+		return fmt.Errorf("ExecNodeInfo() error for node ids %s: %w", strings.Join(failed, ", "), firstErr)
+	}
+	return nil
+}
+
+func (c *nodeCollector) emitNodeInfo(ch chan<- prometheus.Metric, id int, nodeInfo pgpool2.NodeInfo) {
+	labels := []string{
+		strconv.Itoa(id),
+		nodeInfo.Hostname,
+		strconv.Itoa(nodeInfo.Port),
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolNodeStatusCode,
+		prometheus.GaugeValue,
+		float64(nodeInfo.StatusCode),
+		labels...,
+	)
+	emitStateSet(ch, PoolNodeStatus, pgpool2.NodeStates, pgpool2.NodeStatusCodeToState(nodeInfo.StatusCode), labels...)
+	emitStateSet(ch, PoolNodeRole, pgpool2.NodeRoles, pgpool2.NodeRoleToState(nodeInfo.Role), labels...)
+	ch <- prometheus.MustNewConstMetric(
+		PoolNodeWeight,
+		prometheus.GaugeValue,
+		nodeInfo.Weight,
+		labels...,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		PoolNodeReplicationDelay,
+		prometheus.GaugeValue,
+		nodeInfo.ReplicationDelay,
+		labels...,
+	)
+	if !nodeInfo.LastStatusChangeTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(
+			PoolNodeLastStatusChange,
+			prometheus.GaugeValue,
+			float64(nodeInfo.LastStatusChangeTime.Unix()),
+			labels...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			PoolNodeTimeInState,
+			prometheus.GaugeValue,
+			time.Since(nodeInfo.LastStatusChangeTime).Seconds(),
+			append(labels, pgpool2.NodeStatusCodeToState(nodeInfo.StatusCode))...,
+		)
+	}
+	if !c.legacyInfo {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolNodeInfo,
+		prometheus.GaugeValue,
+		float64(nodeInfo.StatusCode),
+		strconv.Itoa(id),
+		nodeInfo.Hostname,
+		strconv.Itoa(nodeInfo.Port),
+		strconv.FormatFloat(nodeInfo.Weight, 'f', 6, 64),
+		nodeInfo.Role,
+		strconv.FormatFloat(nodeInfo.ReplicationDelay, 'f', 6, 64),
+		nodeInfo.ReplicationState,
+		nodeInfo.ReplicationSyncState,
+		nodeInfo.LastStatusChange,
+	)
+}
+
+func (c *nodeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PoolNodeCount
+	ch <- PoolNodeInfo
+	ch <- PoolNodeStatusCode
+	ch <- PoolNodeStatus
+	ch <- PoolNodeRole
+	ch <- PoolNodeWeight
+	ch <- PoolNodeReplicationDelay
+	ch <- PoolNodeLastStatusChange
+	ch <- PoolNodeTimeInState
+	ch <- PoolNodeScrapeError
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	PoolProcCount = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "proc_count"),
+		"Displays number of all Pgpool-II children processes",
+		nil, nil,
+	)
+)
+
+// procCountCollector exports the number of pgpool children from pcp_proc_count.
+type procCountCollector struct {
+	pgpool pgpool2.Source
+}
+
+func init() {
+	registerCollector("proc_count", true, func(pgpool pgpool2.Source) (Collector, error) {
+		return &procCountCollector{pgpool: pgpool}, nil
+	})
+}
+
+func (c *procCountCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	procArr, err := c.pgpool.ExecProcCount(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecProcCount() error: %w", err)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolProcCount,
+		prometheus.GaugeValue,
+		float64(len(procArr)),
+	)
+	return nil
+}
+
+func (c *procCountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PoolProcCount
+}
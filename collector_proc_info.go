@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	PoolNumberActiveConnections = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_active_connections"),
+		"Displays number of all active connections to all Pgpool-II children processes",
+		[]string{"database"}, nil,
+	)
+	PoolNumberInactiveConnections = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_inactive_connections"),
+		"Displays number of all inactive connections to all Pgpool-II children processes",
+		[]string{"database"}, nil,
+	)
+	PoolFrontendConnections = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "frontend_connections"),
+		"Number of clients connected to Pgpool-II children processes",
+		[]string{"database", "username", "client_host"}, nil,
+	)
+	PoolChildren = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "children"),
+		"Number of Pgpool-II children processes by status",
+		[]string{"status"}, nil,
+	)
+	PoolChildrenSaturation = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "children_saturation"),
+		"Share of Pgpool-II children processes serving a client (clients queue at 1)",
+		nil, nil,
+	)
+)
+
+var (
+	procInfoDatabaseAllow, procInfoDatabaseDeny     = labelLimitFlags("proc_info", "database")
+	procInfoUsernameAllow, procInfoUsernameDeny     = labelLimitFlags("proc_info", "username")
+	procInfoClientHostAllow, procInfoClientHostDeny = labelLimitFlags("proc_info", "client-host")
+	procInfoMaxSeries                               = flag.Int("collector.proc_info.max-series-per-label", 100, "Maximum number of values of each label of pgpool2_frontend_connections, the rest is reported as \""+overflowLabelValue+"\" (0 disables the limit)")
+)
+
+// procInfoCollector exports frontend connections from pcp_proc_info.
+type procInfoCollector struct {
+	pgpool     pgpool2.Source
+	database   labelLimit
+	username   labelLimit
+	clientHost labelLimit
+}
+
+func init() {
+	registerCollector("proc_info", true, func(pgpool pgpool2.Source) (Collector, error) {
+		c := &procInfoCollector{pgpool: pgpool}
+		var err error
+		if c.database, err = newLabelLimit(*procInfoDatabaseAllow, *procInfoDatabaseDeny, *procInfoMaxSeries); err != nil {
+			return nil, fmt.Errorf("database filter: %w", err)
+		}
+		if c.username, err = newLabelLimit(*procInfoUsernameAllow, *procInfoUsernameDeny, *procInfoMaxSeries); err != nil {
+			return nil, fmt.Errorf("username filter: %w", err)
+		}
+		if c.clientHost, err = newLabelLimit(*procInfoClientHostAllow, *procInfoClientHostDeny, *procInfoMaxSeries); err != nil {
+			return nil, fmt.Errorf("client host filter: %w", err)
+		}
+		return c, nil
+	})
+}
+
+func (c *procInfoCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	procInfoArr, err := c.pgpool.ExecProcInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecProcInfo() error: %w", err)
+	}
+	procSummary := pgpool2.SummarizeProcInfo(procInfoArr)
+	for database, counter := range procSummary.Active {
+		ch <- prometheus.MustNewConstMetric(
+			PoolNumberActiveConnections,
+			prometheus.GaugeValue,
+			float64(counter),
+			database,
+		)
+	}
+	for database, counter := range procSummary.Inactive {
+		ch <- prometheus.MustNewConstMetric(
+			PoolNumberInactiveConnections,
+			prometheus.GaugeValue,
+			float64(counter),
+			database,
+		)
+	}
+	c.emitConnections(ch, pgpool2.FrontendConnections(procInfoArr))
+	c.emitChildren(ch, pgpool2.SummarizeChildren(procInfoArr))
+	return nil
+}
+
+// emitConnections counts the connections by database, user and client
+// host, limiting the values of each label.
+func (c *procInfoCollector) emitConnections(ch chan<- prometheus.Metric, connections []pgpool2.ProcInfo) {
+	databases := make(map[string]int)
+	usernames := make(map[string]int)
+	clientHosts := make(map[string]int)
+	for _, conn := range connections {
+		databases[conn.Database]++
+		usernames[conn.Username]++
+		clientHosts[conn.ClientHost]++
+	}
+	databaseLabels := c.database.apply(databases)
+	usernameLabels := c.username.apply(usernames)
+	clientHostLabels := c.clientHost.apply(clientHosts)
+	counts := make(map[[3]string]int)
+	for _, conn := range connections {
+		counts[[3]string{
+			databaseLabels[conn.Database],
+			usernameLabels[conn.Username],
+			clientHostLabels[conn.ClientHost],
+		}]++
+	}
+	for labels, count := range counts {
+		ch <- prometheus.MustNewConstMetric(
+			PoolFrontendConnections,
+			prometheus.GaugeValue,
+			float64(count),
+			labels[:]...,
+		)
+	}
+}
+
+func (c *procInfoCollector) emitChildren(ch chan<- prometheus.Metric, summary pgpool2.ChildrenSummary) {
+	// keep the series of idle statuses present while they are 0
+	statuses := []string{pgpool2.ChildStatusWaitForConnection, pgpool2.ChildStatusConnected}
+	if summary.HasStatus {
+		statuses = pgpool2.ChildStatuses
+	}
+	for status := range summary.Status {
+		if !containsString(statuses, status) {
+			statuses = append(statuses, status)
+		}
+	}
+	for _, status := range statuses {
+		ch <- prometheus.MustNewConstMetric(
+			PoolChildren,
+			prometheus.GaugeValue,
+			float64(summary.Status[status]),
+			status,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolChildrenSaturation,
+		prometheus.GaugeValue,
+		summary.Saturation(),
+	)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *procInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PoolNumberActiveConnections
+	ch <- PoolNumberInactiveConnections
+	ch <- PoolFrontendConnections
+	ch <- PoolChildren
+	ch <- PoolChildrenSaturation
+}
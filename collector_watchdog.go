@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var (
+	WatchdogTotalNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_total"),
+		"Watchdog total nodes",
+		nil, nil,
+	)
+	WatchdogRemoteNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_remote"),
+		"Watchdog remote nodes",
+		nil, nil,
+	)
+	WatchdogAliveRemoteNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_alive_remote"),
+		"Watchdog alive remote nodes",
+		nil, nil,
+	)
+	WatchdogMemberRemoteNodes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_member_remote"),
+		"Watchdog remote nodes which are members of the cluster (pgpool-II 4.3+)",
+		nil, nil,
+	)
+	WatchdogQuorumNodesRequired = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "nodes_required_for_quorum"),
+		"Watchdog nodes required for quorum (pgpool-II 4.3+)",
+		nil, nil,
+	)
+	WatchdogLeaderInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "leader_info"),
+		"Watchdog leader node, always 1",
+		[]string{"node_name", "host"}, nil,
+	)
+	WatchdogNodeStatus = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_status"),
+		"Whether the watchdog node is in the state of the state label (1 for the current state)",
+		[]string{"node_name", "host", "state"}, nil,
+	)
+	WatchdogNodePriority = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "node_priority"),
+		"Priority of the watchdog node in leader elections",
+		[]string{"node_name", "host"}, nil,
+	)
+	WatchdogIsLeader = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "is_leader"),
+		"Whether the local watchdog node is the leader (1 for yes, 0 for no)",
+		nil, nil,
+	)
+	WatchdogLeaderChanges = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "leader_changes_total"),
+		"Number of times another watchdog node became leader since the exporter started",
+		nil, nil,
+	)
+	WatchdogEscalations = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "escalations_total"),
+		"Number of times the local node brought up the virtual IP since the exporter started",
+		nil, nil,
+	)
+	WatchdogPeerUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "peer_up"),
+		"Whether the PCP endpoint of the peer answered (1 for yes, 0 for no)",
+		[]string{"peer"}, nil,
+	)
+	WatchdogVIPHolders = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "vip_holders"),
+		"Number of pgpool nodes, local one and answering peers, holding the virtual IP (more than 1 is a split brain)",
+		nil, nil,
+	)
+	WatchdogVIP = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "vip"),
+		"Watchdog virtual IP",
+		nil, nil,
+	)
+	WatchdogQuorumState = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "quorum_state"),
+		"Watchdog quorum state (1 is ok)",
+		nil, nil,
+	)
+	WatchdogQuorum = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "watchdog", "quorum"),
+		"Whether the watchdog quorum is in the state of the state label (1 for the current state)",
+		[]string{"state"}, nil,
+	)
+)
+
+// quorumStates are the values of the state label of pgpool2_watchdog_quorum,
+// ordered by quorum state code
+var quorumStates = func() []string {
+	codes := make([]int, 0, len(pgpool2.QuorumStates))
+	for code := range pgpool2.QuorumStates {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	states := make([]string, len(codes))
+	for i, code := range codes {
+		states[i] = pgpool2.QuorumStates[code]
+	}
+	return states
+}()
+
+var watchdogPeers = flag.String("collector.watchdog.peers", "", "Comma separated PCP addresses (host:port) of the other pgpool nodes of the watchdog cluster, to count the nodes holding the virtual IP")
+
+// watchdogCollector exports the watchdog cluster state from pcp_watchdog_info.
+type watchdogCollector struct {
+	pgpool pgpool2.Source
+	peers  map[string]pgpool2.Source
+
+	// mu guards the state tracked across scrapes
+	mu            sync.Mutex
+	scraped       bool
+	leader        string
+	vip           bool
+	leaderChanges uint64
+	escalations   uint64
+}
+
+func init() {
+	registerCollector("watchdog", true, func(pgpool pgpool2.Source) (Collector, error) {
+		c := &watchdogCollector{
+			pgpool: pgpool,
+			peers:  make(map[string]pgpool2.Source),
+		}
+		for _, address := range strings.Split(*watchdogPeers, ",") {
+			address = strings.TrimSpace(address)
+			if len(address) == 0 {
+				continue
+			}
+			peer, err := pgpool.Peer(address)
+			if err != nil {
+				return nil, err
+			}
+			c.peers[address] = peer
+		}
+		return c, nil
+	})
+}
+
+// track counts the leader changes and escalations since the last scrape.
+func (c *watchdogCollector) track(watchdogInfo pgpool2.WatchdogInfo) (leaderChanges, escalations uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.scraped {
+		if len(watchdogInfo.LeaderNodeName) != 0 && len(c.leader) != 0 && watchdogInfo.LeaderNodeName != c.leader {
+			c.leaderChanges++
+		}
+		if watchdogInfo.VIP && !c.vip {
+			c.escalations++
+		}
+	}
+	c.scraped = true
+	if len(watchdogInfo.LeaderNodeName) != 0 {
+		c.leader = watchdogInfo.LeaderNodeName
+	}
+	c.vip = watchdogInfo.VIP
+	return c.leaderChanges, c.escalations
+}
+
+// collectVIPHolders asks every peer whether it holds the virtual IP.
+func (c *watchdogCollector) collectVIPHolders(ctx context.Context, ch chan<- prometheus.Metric, localVIP bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	holders := 0
+	if localVIP {
+		holders++
+	}
+	for address, peer := range c.peers {
+		wg.Add(1)
+		go func(address string, peer pgpool2.Source) {
+			defer wg.Done()
+			up := 1.0
+			watchdogInfo, err := peer.ExecWatchdogInfo(ctx)
+			if err != nil {
+				logrus.Warnf("Watchdog peer %s is unavailable: %v", address, err)
+				up = 0.0
+			}
+			ch <- prometheus.MustNewConstMetric(
+				WatchdogPeerUp,
+				prometheus.GaugeValue,
+				up,
+				address,
+			)
+			if err == nil && watchdogInfo.VIP {
+				mu.Lock()
+				holders++
+				mu.Unlock()
+			}
+		}(address, peer)
+	}
+	wg.Wait()
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogVIPHolders,
+		prometheus.GaugeValue,
+		float64(holders),
+	)
+}
+
+func (c *watchdogCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	watchdogInfo, err := c.pgpool.ExecWatchdogInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("ExecWatchdogInfo() error: %w", err)
+	}
+	leaderChanges, escalations := c.track(watchdogInfo)
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogLeaderChanges,
+		prometheus.CounterValue,
+		float64(leaderChanges),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogEscalations,
+		prometheus.CounterValue,
+		float64(escalations),
+	)
+	if len(c.peers) != 0 {
+		c.collectVIPHolders(ctx, ch, watchdogInfo.VIP)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogTotalNodes,
+		prometheus.GaugeValue,
+		float64(watchdogInfo.TotalNodes),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogRemoteNodes,
+		prometheus.GaugeValue,
+		float64(watchdogInfo.RemoteNodes),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogAliveRemoteNodes,
+		prometheus.GaugeValue,
+		float64(watchdogInfo.AliveRemoteNodes),
+	)
+	if watchdogInfo.Membership {
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogMemberRemoteNodes,
+			prometheus.GaugeValue,
+			float64(watchdogInfo.MemberRemoteNodes),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogQuorumNodesRequired,
+			prometheus.GaugeValue,
+			float64(watchdogInfo.QuorumNodesRequired),
+		)
+	}
+	if len(watchdogInfo.LeaderNodeName) != 0 {
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogLeaderInfo,
+			prometheus.GaugeValue,
+			1.0,
+			watchdogInfo.LeaderNodeName,
+			watchdogInfo.LeaderHostName,
+		)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		WatchdogQuorumState,
+		prometheus.GaugeValue,
+		float64(watchdogInfo.QuorumStateCode),
+	)
+	emitStateSet(ch, WatchdogQuorum, quorumStates, pgpool2.QuorumStates[watchdogInfo.QuorumStateCode])
+	for _, node := range watchdogInfo.Nodes {
+		emitStateSet(ch, WatchdogNodeStatus, pgpool2.WatchdogStates, pgpool2.WatchdogNodeState(node.StatusName), node.NodeName, node.HostName)
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogNodePriority,
+			prometheus.GaugeValue,
+			float64(node.Priority),
+			node.NodeName,
+			node.HostName,
+		)
+	}
+	if len(watchdogInfo.Nodes) != 0 {
+		isLeader := 0.0
+		if watchdogInfo.IsLeader() {
+			isLeader = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogIsLeader,
+			prometheus.GaugeValue,
+			isLeader,
+		)
+	}
+	if watchdogInfo.VIP {
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogVIP,
+			prometheus.GaugeValue,
+			1.0,
+		)
+	} else {
+		ch <- prometheus.MustNewConstMetric(
+			WatchdogVIP,
+			prometheus.GaugeValue,
+			0.0,
+		)
+	}
+	return nil
+}
+
+func (c *watchdogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- WatchdogTotalNodes
+	ch <- WatchdogRemoteNodes
+	ch <- WatchdogAliveRemoteNodes
+	ch <- WatchdogMemberRemoteNodes
+	ch <- WatchdogQuorumNodesRequired
+	ch <- WatchdogLeaderInfo
+	ch <- WatchdogQuorumState
+	ch <- WatchdogQuorum
+	ch <- WatchdogNodeStatus
+	ch <- WatchdogNodePriority
+	ch <- WatchdogIsLeader
+	ch <- WatchdogLeaderChanges
+	ch <- WatchdogEscalations
+	ch <- WatchdogPeerUp
+	ch <- WatchdogVIPHolders
+	ch <- WatchdogVIP
+}
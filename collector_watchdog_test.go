@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+func TestWatchdogTrack(t *testing.T) {
+	// pgpool reports no leader while an election is going on
+	f, err := os.Open("pgpool2/testdata/4.2/pcp_watchdog_info_no_leader.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	election, err := pgpool2.WatchdogInfoUnmarshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader := func(name string, vip bool) pgpool2.WatchdogInfo {
+		return pgpool2.WatchdogInfo{LeaderNodeName: name, VIP: vip}
+	}
+
+	tests := []struct {
+		name              string
+		scrapes           []pgpool2.WatchdogInfo
+		wantLeaderChanges uint64
+		wantEscalations   uint64
+	}{
+		{
+			name:    "stable",
+			scrapes: []pgpool2.WatchdogInfo{leader("a", true), leader("a", true), leader("a", true)},
+		},
+		{
+			name:              "failover",
+			scrapes:           []pgpool2.WatchdogInfo{leader("a", false), leader("b", false), leader("a", false)},
+			wantLeaderChanges: 2,
+		},
+		{
+			name:    "election keeping the leader",
+			scrapes: []pgpool2.WatchdogInfo{leader("a", false), election, leader("a", false)},
+		},
+		{
+			name:              "election changing the leader",
+			scrapes:           []pgpool2.WatchdogInfo{leader("a", false), election, election, leader("b", false)},
+			wantLeaderChanges: 1,
+		},
+		{
+			// holding the VIP at the first scrape is no escalation
+			name:            "escalations",
+			scrapes:         []pgpool2.WatchdogInfo{leader("a", true), leader("a", false), leader("a", true), leader("a", true), leader("a", false), leader("a", true)},
+			wantEscalations: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &watchdogCollector{}
+			var leaderChanges, escalations uint64
+			for _, watchdogInfo := range tt.scrapes {
+				leaderChanges, escalations = c.track(watchdogInfo)
+			}
+			if leaderChanges != tt.wantLeaderChanges {
+				t.Errorf("got %d leader changes, want %d", leaderChanges, tt.wantLeaderChanges)
+			}
+			if escalations != tt.wantEscalations {
+				t.Errorf("got %d escalations, want %d", escalations, tt.wantEscalations)
+			}
+		})
+	}
+}
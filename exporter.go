@@ -1,15 +1,17 @@
 package main
 
 import (
-	"strconv"
-	"time"
-
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/unchris/pgpool2-exporter/pgpool2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/version"
 	"github.com/sirupsen/logrus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
 )
 
 const (
@@ -18,6 +20,21 @@ const (
 )
 
 var (
+	PoolUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"Whether pgpool accepted the connection and authentication of the backend (1 for yes, 0 for no)",
+		nil, nil,
+	)
+	PoolScrapeCollectorSuccess = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+		"Whether a collector succeeded (1 for success, 0 for error)",
+		[]string{"collector"}, nil,
+	)
+	PoolScrapeCollectorDuration = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+		"Duration of a collector scrape",
+		[]string{"collector"}, nil,
+	)
 	PoolLastScrapeError = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "last_scrape_error"),
 		"Whether the last scrape of metrics from Pgpool2 resulted in an error (1 for error, 0 for success)",
@@ -28,185 +45,243 @@ var (
 		"Duration of the last scrape of metrics from Pgpool2",
 		nil, nil,
 	)
-	PoolNodeCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "node_count"),
-		"Displays the total number of database nodes",
-		nil, nil,
+	PoolVersionInfo = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "version_info"),
+		"Version of pgpool, always 1",
+		[]string{"version"}, nil,
 	)
-	PoolNodeInfo = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "node_info"),
-		"Displays the information of node",
-		[]string{"id", "node", "port", "width", "role", "replicationDelay", "replicationState", "replicationSyncState", "lastStatusChange"}, nil,
+	PoolCapability = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "capability"),
+		"Whether the pgpool release supports a feature (1 for yes, 0 for no)",
+		[]string{"feature"}, nil,
 	)
-	PoolProcCount = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "proc_count"),
-		"Displays number of all Pgpool-II children processes",
+	PCPSessionReconnects = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pcp", "reconnects_total"),
+		"Number of times the persistent PCP session had to be re-established",
 		nil, nil,
 	)
-	PoolNumberActiveConnections = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "frontend_active_connections"),
-		"Displays number of all active connections to all Pgpool-II children processes",
-		[]string{"database"}, nil,
-	)
-	PoolNumberInactiveConnections = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "frontend_inactive_connections"),
-		"Displays number of all inactive connections to all Pgpool-II children processes",
-		[]string{"database"}, nil,
-	)
-	WatchdogTotalNodes = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "nodes_total"),
-		"Watchdog total nodes",
-		nil, nil,
-	)
-	WatchdogRemoteNodes = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "nodes_remote"),
-		"Watchdog remote nodes",
-		nil, nil,
-	)
-	WatchdogAliveRemoteNodes = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "nodes_alive_remote"),
-		"Watchdog alive remote nodes",
+	PCPSessionAge = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pcp", "session_age_seconds"),
+		"Age of the current PCP session (0 if disconnected)",
 		nil, nil,
 	)
-	WatchdogVIP = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "vip"),
-		"Watchdog virtual IP",
+	PCPAuthFailures = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pcp", "auth_failures_total"),
+		"Number of failed PCP authentication attempts",
 		nil, nil,
 	)
-	WatchdogQuorumState = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "watchdog", "quorum_state"),
-		"Watchdog quorum state (1 is ok)",
+	PCPLastAuthFailure = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "pcp", "last_auth_failure_timestamp_seconds"),
+		"Time of the last failed PCP authentication attempt (0 if never)",
 		nil, nil,
 	)
 )
 
 type Exporter struct {
-	pgpool *pgpool2.Client
+	pgpool      pgpool2.Source
+	source      pgpool2.Source
+	collectors  map[string]Collector
+	pcpTimeouts *prometheus.CounterVec
+	pcpErrors   *prometheus.CounterVec
+}
+
+// contextCollector runs the scrapes of an Exporter under a context,
+// restricted to the named collectors.
+type contextCollector struct {
+	*Exporter
+	ctx        context.Context
+	collectors []string
 }
 
 func init() {
 	prometheus.MustRegister(version.NewCollector(exporterName))
 }
 
-func NewExporter(pgpool *pgpool2.Client) *Exporter {
-	return &Exporter{
-		pgpool: pgpool,
+// NewExporter creates an Exporter running the collectors enabled by flags.
+func NewExporter(pgpool pgpool2.Source) (*Exporter, error) {
+	e := &Exporter{
+		pgpool:     pgpool,
+		collectors: make(map[string]Collector),
+		pcpTimeouts: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "pcp",
+				Name:      "timeouts_total",
+				Help:      "Number of PCP commands which exceeded their timeout",
+			},
+			[]string{"command"},
+		),
+		pcpErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: namespace,
+				Subsystem: "pcp",
+				Name:      "errors_total",
+				Help:      "Number of failed PCP commands by reason",
+			},
+			[]string{"command", "reason"},
+		),
+	}
+	// the collectors see a source counting the errors of every command
+	e.source = newObservedSource(pgpool, e.observeError)
+	for _, name := range enabledCollectors() {
+		collector, err := collectorFactories[name](e.source)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		e.collectors[name] = collector
 	}
+	return e, nil
 }
 
-func (e *Exporter) collectNodeMetrics(ch chan<- prometheus.Metric) error {
-	nodeCount, err := e.pgpool.ExecNodeCount()
-	if err != nil {
-		return fmt.Errorf("ExecNodeCount() error: %v", err)
+// collectorNames returns the sorted names of the exporter's collectors.
+func (e *Exporter) collectorNames() []string {
+	names := make([]string, 0, len(e.collectors))
+	for name := range e.collectors {
+		names = append(names, name)
 	}
-	ch <- prometheus.MustNewConstMetric(
-		PoolNodeCount,
-		prometheus.GaugeValue,
-		float64(nodeCount),
-	)
-	for i := 0; i < nodeCount; i++ {
-		nodeInfo, err := e.pgpool.ExecNodeInfo(i)
-		if err != nil {
-			return fmt.Errorf("ExecNodeInfo(%d) error: %v", i, err)
+	sort.Strings(names)
+	return names
+}
+
+// WithContext returns a collector whose scrapes are cancelled together
+// with ctx. If names are given only these collectors run; each of them
+// has to be enabled.
+func (e *Exporter) WithContext(ctx context.Context, names ...string) (prometheus.Collector, error) {
+	for _, name := range names {
+		if _, ok := e.collectors[name]; !ok {
+			return nil, fmt.Errorf("unknown or disabled collector %q", name)
 		}
-		ch <- prometheus.MustNewConstMetric(
-			PoolNodeInfo,
-			prometheus.GaugeValue,
-			float64(nodeInfo.StatusCode),
-			strconv.Itoa(i),
-			nodeInfo.Hostname,
-			strconv.Itoa(nodeInfo.Port),
-			strconv.FormatFloat(nodeInfo.Weight, 'f', 6, 64),
-			nodeInfo.Role,
-			strconv.FormatFloat(nodeInfo.ReplicationDelay, 'f', 6, 64),
-			nodeInfo.ReplicationState,
-			nodeInfo.ReplicationSyncState,
-			nodeInfo.LastStatusChange,
-		)
 	}
-	return nil
+	if len(names) == 0 {
+		names = e.collectorNames()
+	}
+	return &contextCollector{
+		Exporter:   e,
+		ctx:        ctx,
+		collectors: names,
+	}, nil
 }
 
-func (e *Exporter) collectProcCountMetrics(ch chan<- prometheus.Metric) error {
-	procArr, err := e.pgpool.ExecProcCount()
-	if err != nil {
-		return fmt.Errorf("ExecProcCount() error: %v", err)
-	}
-	ch <- prometheus.MustNewConstMetric(
-		PoolProcCount,
-		prometheus.GaugeValue,
-		float64(len(procArr)),
-	)
-	return nil
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.ctx, c.collectors, ch)
 }
 
-func (e *Exporter) collectProcInfoMetrics(ch chan<- prometheus.Metric) error {
-	procInfoArr, err := e.pgpool.ExecProcInfo()
-	if err != nil {
-		return fmt.Errorf("ExecProcInfo() error: %v", err)
+func (e *Exporter) observeError(err error) {
+	if err == nil {
+		return
 	}
-	procSummary := e.pgpool.ProcInfoSummary(procInfoArr)
-	for database, counter := range procSummary.Active {
-		ch <- prometheus.MustNewConstMetric(
-			PoolNumberActiveConnections,
-			prometheus.GaugeValue,
-			float64(counter),
-			database,
-		)
+	var cmdErr *pgpool2.CommandError
+	if !errors.As(err, &cmdErr) || errors.Is(err, pgpool2.ErrUnsupported) {
+		return
 	}
-	for database, counter := range procSummary.Inactive {
-		ch <- prometheus.MustNewConstMetric(
-			PoolNumberInactiveConnections,
-			prometheus.GaugeValue,
-			float64(counter),
-			database,
-		)
+	e.pcpErrors.WithLabelValues(cmdErr.Command, pgpool2.ErrorReason(err)).Inc()
+	if errors.Is(err, pgpool2.ErrTimeout) {
+		e.pcpTimeouts.WithLabelValues(cmdErr.Command).Inc()
 	}
-	return nil
 }
 
-func (e *Exporter) collectWatchdogInfoMetrics(ch chan<- prometheus.Metric) error {
-	watchdogInfo, err := e.pgpool.ExecWatchdogInfo()
-	if err != nil {
-		return fmt.Errorf("ExecWatchdogInfo() error: %v", err)
+func (e *Exporter) collectSessionMetrics(ch chan<- prometheus.Metric) {
+	reporter, ok := e.pgpool.(pgpool2.SessionReporter)
+	if !ok {
+		return
+	}
+	stats, ok := reporter.SessionStats()
+	if !ok {
+		return
+	}
+	sessionAge := 0.0
+	if stats.Connected {
+		sessionAge = time.Since(stats.Established).Seconds()
+	}
+	lastAuthFailure := 0.0
+	if !stats.LastAuthFailure.IsZero() {
+		lastAuthFailure = float64(stats.LastAuthFailure.Unix())
 	}
 	ch <- prometheus.MustNewConstMetric(
-		WatchdogTotalNodes,
-		prometheus.GaugeValue,
-		float64(watchdogInfo.TotalNodes),
+		PCPSessionReconnects,
+		prometheus.CounterValue,
+		float64(stats.Reconnects),
 	)
 	ch <- prometheus.MustNewConstMetric(
-		WatchdogRemoteNodes,
+		PCPSessionAge,
 		prometheus.GaugeValue,
-		float64(watchdogInfo.RemoteNodes),
+		sessionAge,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		PCPAuthFailures,
+		prometheus.CounterValue,
+		float64(stats.AuthFailures),
 	)
 	ch <- prometheus.MustNewConstMetric(
-		WatchdogAliveRemoteNodes,
+		PCPLastAuthFailure,
 		prometheus.GaugeValue,
-		float64(watchdogInfo.AliveRemoteNodes),
+		lastAuthFailure,
 	)
+}
+
+func (e *Exporter) collectVersion(ctx context.Context, ch chan<- prometheus.Metric) {
+	version, err := e.source.ServerVersion(ctx)
+	if err != nil {
+		logrus.Debugf("pgpool version is unknown: %v", err)
+		return
+	}
 	ch <- prometheus.MustNewConstMetric(
-		WatchdogQuorumState,
+		PoolVersionInfo,
 		prometheus.GaugeValue,
-		float64(watchdogInfo.QuorumStateCode),
+		1.0,
+		version.String(),
 	)
-	if watchdogInfo.VIP {
-		ch <- prometheus.MustNewConstMetric(
-			WatchdogVIP,
-			prometheus.GaugeValue,
-			1.0,
-		)
-	} else {
+	for _, feature := range pgpool2.Features() {
+		supported := 0.0
+		if version.Supports(feature) {
+			supported = 1.0
+		}
 		ch <- prometheus.MustNewConstMetric(
-			WatchdogVIP,
+			PoolCapability,
 			prometheus.GaugeValue,
-			0.0,
+			supported,
+			feature,
 		)
 	}
-	return nil
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.collect(context.Background(), e.collectorNames(), ch)
+}
+
+// runCollector runs one collector and reports its outcome; it returns
+// false if the collector failed.
+func (e *Exporter) runCollector(ctx context.Context, name string, ch chan<- prometheus.Metric) bool {
+	begun := time.Now()
+	err := e.collectors[name].Update(ctx, ch)
+	duration := time.Since(begun).Seconds()
+	// the data source may lack what the collector needs, e.g. the
+	// watchdog state over SQL
+	if errors.Is(err, ErrNoData) || errors.Is(err, pgpool2.ErrUnsupported) {
+		logrus.Debugf("collector %s skipped: %v", name, err)
+		err = nil
+	}
+	success := 1.0
+	if err != nil {
+		success = 0.0
+		logrus.Errorf("collector %s failed: %v", name, err)
+	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolScrapeCollectorSuccess,
+		prometheus.GaugeValue,
+		success,
+		name,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		PoolScrapeCollectorDuration,
+		prometheus.GaugeValue,
+		duration,
+		name,
+	)
+	return err == nil
+}
+
+func (e *Exporter) collect(ctx context.Context, collectors []string, ch chan<- prometheus.Metric) {
 	var scrapeError bool
 
 	defer func(begun time.Time) {
@@ -217,25 +292,41 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 		)
 	}(time.Now())
 
-	if err := e.collectNodeMetrics(ch); err != nil {
+	up := 1.0
+	if err := e.source.Ping(ctx); err != nil {
+		logrus.Errorf("PCP endpoint is unavailable: %v", err)
+		up = 0.0
 		scrapeError = true
-		logrus.Error(err)
-	}
-
-	if err := e.collectProcCountMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
 	}
+	ch <- prometheus.MustNewConstMetric(
+		PoolUp,
+		prometheus.GaugeValue,
+		up,
+	)
 
-	if err := e.collectProcInfoMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
+	// collectors would only pile up more failures if pgpool is unreachable
+	if up == 1.0 {
+		e.collectVersion(ctx, ch)
+		succeeded := make([]bool, len(collectors))
+		var wg sync.WaitGroup
+		for i, name := range collectors {
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				succeeded[i] = e.runCollector(ctx, name, ch)
+			}(i, name)
+		}
+		wg.Wait()
+		for _, ok := range succeeded {
+			if !ok {
+				scrapeError = true
+			}
+		}
 	}
 
-	if err := e.collectWatchdogInfoMetrics(ch); err != nil {
-		scrapeError = true
-		logrus.Error(err)
-	}
+	e.collectSessionMetrics(ch)
+	e.pcpTimeouts.Collect(ch)
+	e.pcpErrors.Collect(ch)
 
 	scrapeErrorFloat := 0.0
 	if scrapeError {
@@ -250,16 +341,20 @@ func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
 }
 
 func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- PoolUp
+	ch <- PoolScrapeCollectorSuccess
+	ch <- PoolScrapeCollectorDuration
 	ch <- PoolLastScrapeError
 	ch <- PoolLastScrapeDuration
-	ch <- PoolNodeCount
-	ch <- PoolProcCount
-	ch <- PoolNodeInfo
-	ch <- PoolNumberActiveConnections
-	ch <- PoolNumberInactiveConnections
-	ch <- WatchdogTotalNodes
-	ch <- WatchdogRemoteNodes
-	ch <- WatchdogAliveRemoteNodes
-	ch <- WatchdogQuorumState
-	ch <- WatchdogVIP
+	ch <- PoolVersionInfo
+	ch <- PoolCapability
+	ch <- PCPSessionReconnects
+	ch <- PCPSessionAge
+	ch <- PCPAuthFailures
+	ch <- PCPLastAuthFailure
+	e.pcpTimeouts.Describe(ch)
+	e.pcpErrors.Describe(ch)
+	for _, collector := range e.collectors {
+		collector.Describe(ch)
+	}
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+	"github.com/unchris/pgpool2-exporter/pgpool2"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata")
+
+// volatileMetrics change from run to run and are left out of the golden
+// files
+var volatileMetrics = []string{
+	"go_",
+	"process_",
+	"pgpool2_exporter_build_info",
+	"pgpool2_last_scrape_duration_seconds",
+	"pgpool2_scrape_collector_duration_seconds",
+	"pgpool2_node_time_in_state_seconds",
+}
+
+func init() {
+	logrus.SetOutput(ioutil.Discard)
+}
+
+func isVolatile(name string) bool {
+	for _, prefix := range volatileMetrics {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrape requests /metrics with query from handler and returns the
+// exposition.
+func scrape(t testing.TB, handler http.Handler, query string) []byte {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics"+query, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /metrics%s: status %d: %s", query, recorder.Code, recorder.Body)
+	}
+	return recorder.Body.Bytes()
+}
+
+// parseExposition returns the stable metric families of a text
+// exposition, sorted by name like the registry sorts them.
+func parseExposition(exposition []byte) ([]*dto.MetricFamily, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(exposition))
+	if err != nil {
+		return nil, err
+	}
+	var result []*dto.MetricFamily
+	for name, family := range families {
+		if !isVolatile(name) {
+			result = append(result, family)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetName() < result[j].GetName()
+	})
+	return result, nil
+}
+
+// compareGolden compares the exposition of a scrape against
+// testdata/<name>.prom, or rewrites the file with -update.
+func compareGolden(t *testing.T, name string, exposition []byte) {
+	t.Helper()
+	families, err := parseExposition(exposition)
+	if err != nil {
+		t.Fatalf("invalid exposition: %v\n%s", err, exposition)
+	}
+	golden := filepath.Join("testdata", name+".prom")
+	if *update {
+		buf := &bytes.Buffer{}
+		encoder := expfmt.NewEncoder(buf, expfmt.FmtText)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := os.Open(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expected.Close()
+	gatherer := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return families, nil
+	})
+	if err := testutil.GatherAndCompare(gatherer, expected); err != nil {
+		t.Error(err)
+	}
+}
+
+func newTestHandler(t testing.TB, source pgpool2.Source) http.Handler {
+	t.Helper()
+	exporter, err := NewExporter(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metricsHandler(exporter)
+}
+
+// healthySource is a pgpool-II 4.2 cluster of a primary and a standby
+// with a two node watchdog.
+func healthySource() *pgpool2.FakeSource {
+	changed := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	return &pgpool2.FakeSource{
+		Version: pgpool2.Version{Major: 4, Minor: 2, Patch: 3},
+		Nodes: []pgpool2.NodeInfo{
+			{
+				Hostname:             "pg0",
+				Port:                 5432,
+				StatusCode:           2,
+				Status:               pgpool2.NodeStatusUP2,
+				Weight:               0.5,
+				Role:                 "primary",
+				LastStatusChange:     "2021-01-01 10:00:00",
+				LastStatusChangeTime: changed,
+			},
+			{
+				Hostname:             "pg1",
+				Port:                 5432,
+				StatusCode:           2,
+				Status:               pgpool2.NodeStatusUP2,
+				Weight:               0.5,
+				Role:                 "standby",
+				ReplicationDelay:     128,
+				ReplicationState:     "streaming",
+				ReplicationSyncState: "async",
+				LastStatusChange:     "2021-01-01 10:00:00",
+				LastStatusChangeTime: changed,
+			},
+		},
+		Procs: []string{"100", "101", "102"},
+		ProcInfo: []pgpool2.ProcInfo{
+			{PID: 100, Database: "app", Username: "web", Connected: true, ClientHost: "10.0.0.5", Status: "Idle"},
+			{PID: 100, Database: "app", Username: "web", Connected: true, ClientHost: "10.0.0.5", Status: "Idle"},
+			{PID: 101, Database: "app", Username: "batch", Connected: true, ClientHost: "10.0.0.6", Status: "Execute command"},
+			{PID: 102, Status: "Wait for connection"},
+		},
+		Watchdog: pgpool2.WatchdogInfo{
+			TotalNodes:       2,
+			RemoteNodes:      1,
+			AliveRemoteNodes: 1,
+			QuorumState:      "QUORUM EXIST",
+			QuorumStateCode:  pgpool2.QuorumStateExist,
+			VIP:              true,
+			LeaderNodeName:   "pgpool0:9999 Linux pgpool0",
+			LeaderHostName:   "pgpool0",
+			Nodes: []pgpool2.WatchdogNode{
+				{NodeName: "pgpool0:9999 Linux pgpool0", HostName: "pgpool0", Priority: 2, Status: 4, StatusName: "LEADER"},
+				{NodeName: "pgpool1:9999 Linux pgpool1", HostName: "pgpool1", Priority: 1, Status: 7, StatusName: "STANDBY"},
+			},
+		},
+		PoolCache: pgpool2.PoolCache{
+			CacheHits: 300, Selects: 1200, CacheHitRatio: 0.25,
+			HashEntries: 2048, UsedHashEntries: 64, CacheEntries: 64,
+			UsedCacheEntriesSize: 131072, FreeCacheEntriesSize: 4063232, FragmentCacheEntriesSize: 0,
+		},
+		HealthCheck: []pgpool2.HealthCheckStats{
+			{
+				NodeID: 0, Hostname: "pg0", Port: 5432, Status: "up", Role: "primary",
+				TotalCount: 100, SuccessCount: 99, FailCount: 1, RetryCount: 2, AverageRetryCount: 0.02, MaxRetryCount: 2,
+				MaxDuration: 20 * time.Millisecond, MinDuration: time.Millisecond, AverageDuration: 4 * time.Millisecond,
+				LastHealthCheck: changed, LastSuccessfulHealthCheck: changed,
+			},
+			{
+				NodeID: 1, Hostname: "pg1", Port: 5432, Status: "up", Role: "standby",
+				TotalCount: 100, SuccessCount: 100,
+				MaxDuration: 10 * time.Millisecond, MinDuration: time.Millisecond, AverageDuration: 2 * time.Millisecond,
+				LastHealthCheck: changed, LastSuccessfulHealthCheck: changed,
+			},
+		},
+	}
+}
+
+func TestMetricsHealthy(t *testing.T) {
+	handler := newTestHandler(t, healthySource())
+	compareGolden(t, "healthy", scrape(t, handler, ""))
+}
+
+func TestMetricsPingFailed(t *testing.T) {
+	source := healthySource()
+	source.PingErr = &pgpool2.CommandError{
+		Command: "pcp_node_count",
+		Reason:  pgpool2.ErrConnectionRefused,
+		Err:     errors.New("connection refused"),
+	}
+	handler := newTestHandler(t, source)
+	compareGolden(t, "ping_failed", scrape(t, handler, ""))
+}
+
+func TestMetricsNodeFailed(t *testing.T) {
+	source := healthySource()
+	// pgpool-II 4.0 needs one pcp_node_info call per node
+	source.Version = pgpool2.Version{Major: 4, Minor: 0, Patch: 11}
+	source.NodeInfoErr = map[int]error{
+		1: &pgpool2.CommandError{
+			Command: "pcp_node_info",
+			Reason:  pgpool2.ErrTimeout,
+			Err:     errors.New("signal: killed"),
+		},
+	}
+	handler := newTestHandler(t, source)
+	compareGolden(t, "node_failed", scrape(t, handler, ""))
+}
+
+func TestMetricsCollectFilter(t *testing.T) {
+	handler := newTestHandler(t, healthySource())
+	compareGolden(t, "collect_filter", scrape(t, handler, "?collect[]=node&collect[]=proc_count"))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics?collect[]=unknown", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown collector: got status %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+// BenchmarkScrape measures a scrape of pgpool-II 4.0, which needs one
+// pcp_node_info call per node, with sequential and concurrent calls.
+func BenchmarkScrape(b *testing.B) {
+	defaultWorkers := *nodeInfoWorkers
+	defer func() { *nodeInfoWorkers = defaultWorkers }()
+	for _, nodes := range []int{8, 16, 32, 64} {
+		source := healthySource()
+		source.Version = pgpool2.Version{Major: 4, Minor: 0, Patch: 11}
+		source.Delay = time.Millisecond
+		source.Nodes = make([]pgpool2.NodeInfo, nodes)
+		for i := range source.Nodes {
+			source.Nodes[i] = pgpool2.NodeInfo{
+				Hostname:   fmt.Sprintf("pg%d", i),
+				Port:       5432,
+				StatusCode: 2,
+				Status:     pgpool2.NodeStatusUP2,
+				Role:       "standby",
+			}
+		}
+		for _, workers := range []int{1, defaultWorkers} {
+			b.Run(fmt.Sprintf("nodes=%d/workers=%d", nodes, workers), func(b *testing.B) {
+				*nodeInfoWorkers = workers
+				handler := newTestHandler(b, source)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					scrape(b, handler, "")
+				}
+			})
+		}
+	}
+}
+
+func TestMetricsAllNodeInfoFailed(t *testing.T) {
+	source := healthySource()
+	source.AllNodeInfoErr = &pgpool2.CommandError{
+		Command: "pcp_node_info",
+		Reason:  pgpool2.ErrTimeout,
+		Err:     errors.New("signal: killed"),
+	}
+	handler := newTestHandler(t, source)
+	// the nodes are fetched one by one instead
+	compareGolden(t, "all_node_info_failed", scrape(t, handler, "?collect[]=node"))
+}
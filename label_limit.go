@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// overflowLabelValue replaces the label values dropped by a labelLimit. It
+// is no valid host name and unlikely to be a database or user name.
+const overflowLabelValue = "__other__"
+
+// labelLimit keeps the values of a label allowed by the allow and deny
+// regular expressions, at most max of them; all others are reported as
+// overflowLabelValue.
+type labelLimit struct {
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+	max   int
+}
+
+// labelLimitFlags registers the --collector.<collector>.<label>-allow and
+// -deny flags.
+func labelLimitFlags(collector, label string) (allow, deny *string) {
+	prefix := fmt.Sprintf("collector.%s.%s", collector, label)
+	allow = flag.String(prefix+"-allow", "", fmt.Sprintf("Regexp of %s label values to export (default: all)", label))
+	deny = flag.String(prefix+"-deny", "", fmt.Sprintf("Regexp of %s label values to report as %q", label, overflowLabelValue))
+	return allow, deny
+}
+
+// newLabelLimit compiles the allow and deny flags, which have to match the
+// whole label value. Empty expressions are not applied.
+func newLabelLimit(allow, deny string, max int) (labelLimit, error) {
+	limit := labelLimit{max: max}
+	var err error
+	if len(allow) != 0 {
+		if limit.allow, err = regexp.Compile("^(?:" + allow + ")$"); err != nil {
+			return limit, err
+		}
+	}
+	if len(deny) != 0 {
+		if limit.deny, err = regexp.Compile("^(?:" + deny + ")$"); err != nil {
+			return limit, err
+		}
+	}
+	return limit, nil
+}
+
+func (l labelLimit) allowed(value string) bool {
+	if l.allow != nil && !l.allow.MatchString(value) {
+		return false
+	}
+	return l.deny == nil || !l.deny.MatchString(value)
+}
+
+// apply maps every value of counts to the label value to export. Above
+// the limit the values with the most occurrences are kept.
+func (l labelLimit) apply(counts map[string]int) map[string]string {
+	var allowed []string
+	mapping := make(map[string]string, len(counts))
+	for value := range counts {
+		mapping[value] = overflowLabelValue
+		if l.allowed(value) {
+			allowed = append(allowed, value)
+		}
+	}
+	sort.Slice(allowed, func(i, j int) bool {
+		if counts[allowed[i]] != counts[allowed[j]] {
+			return counts[allowed[i]] > counts[allowed[j]]
+		}
+		return allowed[i] < allowed[j]
+	})
+	if l.max > 0 && len(allowed) > l.max {
+		allowed = allowed[:l.max]
+	}
+	for _, value := range allowed {
+		mapping[value] = value
+	}
+	return mapping
+}
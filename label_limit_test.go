@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLabelLimitApply(t *testing.T) {
+	counts := map[string]int{"app": 5, "reporting": 3, "postgres": 1, "template1": 1}
+	tests := []struct {
+		name  string
+		allow string
+		deny  string
+		max   int
+		want  map[string]string
+	}{
+		{
+			name: "unlimited",
+			want: map[string]string{"app": "app", "reporting": "reporting", "postgres": "postgres", "template1": "template1"},
+		},
+		{
+			// the expressions match whole values, "app" does not allow
+			// "postgres"
+			name:  "allow",
+			allow: "app|report.*",
+			want:  map[string]string{"app": "app", "reporting": "reporting", "postgres": "__other__", "template1": "__other__"},
+		},
+		{
+			name: "deny",
+			deny: "postgres|template[0-9]",
+			want: map[string]string{"app": "app", "reporting": "reporting", "postgres": "__other__", "template1": "__other__"},
+		},
+		{
+			name:  "deny overrides allow",
+			allow: ".*",
+			deny:  "reporting",
+			want:  map[string]string{"app": "app", "reporting": "__other__", "postgres": "postgres", "template1": "template1"},
+		},
+		{
+			// ties are broken by name
+			name: "top 3",
+			max:  3,
+			want: map[string]string{"app": "app", "reporting": "reporting", "postgres": "postgres", "template1": "__other__"},
+		},
+		{
+			// the limit applies to the allowed values only
+			name: "top 1 after deny",
+			deny: "app",
+			max:  1,
+			want: map[string]string{"app": "__other__", "reporting": "reporting", "postgres": "__other__", "template1": "__other__"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit, err := newLabelLimit(tt.allow, tt.deny, tt.max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := limit.apply(counts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLabelLimitInvalid(t *testing.T) {
+	if _, err := newLabelLimit("(", "", 0); err == nil {
+		t.Error("invalid allow expression was accepted")
+	}
+	if _, err := newLabelLimit("", "[", 0); err == nil {
+		t.Error("invalid deny expression was accepted")
+	}
+}
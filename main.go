@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/unchris/pgpool2-exporter/pgpool2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -19,18 +23,70 @@ var (
 	showVersion   = flag.Bool("version", false, "Prints version information and exit")
 	metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
 	listenAddress = flag.String("web.listen-address", ":9288", "Address on which to expose metrics and web interface.")
+	pcpBackend    = flag.String("pcp.backend", pgpool2.BackendExec, "PCP backend: exec (pcp_* binaries), native (built-in protocol client) or sql (SHOW commands on --pgpool.dsn, no PCP access needed)")
 	pcpPassFile   = flag.String("pcp.passfile", "", "Path to the PCP password file containing hostname:port:username:password")
-	pcpHostname   = flag.String("pcp.host", "127.0.0.1", "PCP hostname")
+	pcpHostname   = flag.String("pcp.host", "127.0.0.1", "PCP hostname, or the directory of the PCP Unix domain socket if it starts with /")
 	pcpPort       = flag.Int("pcp.port", 9898, "PCP port")
 	pcpUsername   = flag.String("pcp.username", "pcpadmin", "PCP username")
 	pcpPassword   = flag.String("pcp.password", "", "PCP password")
+	pcpTimezone   = flag.String("pcp.timezone", "", "Time zone of the timestamps formatted by pgpool, e.g. Europe/Berlin (default: local time zone)")
+	pgpoolVersion = flag.String("pgpool.version", "", "pgpool-II release, e.g. 4.2.3 (default: detected with SHOW POOL_VERSION on --pgpool.dsn, or from the pcp_* binaries of the exec backend; unknown for the native backend without --pgpool.dsn)")
+	pcpTimeout    = flag.Duration("pcp.timeout", 10*time.Second, "Timeout of a single PCP command (0 disables it)")
+	pgpoolDSN     = flag.String("pgpool.dsn", "", "Connection string of a monitoring user on the pgpool frontend for SHOW POOL_* commands, e.g. postgres://monitor@localhost:9999/postgres")
 )
 
+// scrapeTimeoutOffset is subtracted from the scrape timeout announced by
+// Prometheus to leave time for sending the response.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
 func versionInfo() {
 	fmt.Println(version.Print(exporterName))
 	os.Exit(0)
 }
 
+// scrapeContext bounds the scrape by the X-Prometheus-Scrape-Timeout-Seconds
+// header of the request, if present.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if len(header) == 0 {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 0 {
+		logrus.Warnf("Invalid X-Prometheus-Scrape-Timeout-Seconds %q", header)
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// metricsHandler registers the exporter for each request in a fresh registry
+// so the scrape runs under the context of the request.
+func metricsHandler(exporter *Exporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		collector, err := exporter.WithContext(ctx, r.URL.Query()["collect[]"]...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(collector)
+		gatherers := prometheus.Gatherers{
+			prometheus.DefaultGatherer,
+			registry,
+		}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{
+			ErrorLog:      logrus.StandardLogger(),
+			ErrorHandling: promhttp.ContinueOnError,
+		}).ServeHTTP(w, r)
+	})
+}
+
 func main() {
 	flag.Parse()
 
@@ -45,12 +101,26 @@ func main() {
 	logrus.Infof("Starting %s %s...", exporterName, version.Version)
 	logrus.Infof("Listen address: %s", *listenAddress)
 
+	location := time.Local
+	if len(*pcpTimezone) != 0 {
+		var err error
+		location, err = time.LoadLocation(*pcpTimezone)
+		if err != nil {
+			logrus.Fatalf("Invalid PCP time zone: %v", err)
+		}
+	}
+
 	options := pgpool2.Options{
+		Backend:  *pcpBackend,
 		Username: *pcpUsername,
 		Password: *pcpPassword,
 		Hostname: *pcpHostname,
 		Port:     *pcpPort,
 		PassFile: *pcpPassFile,
+		Timeout:  *pcpTimeout,
+		Location: location,
+		Version:  *pgpoolVersion,
+		DSN:      *pgpoolDSN,
 	}
 
 	pgpool2Client, err := pgpool2.NewClient(options)
@@ -75,12 +145,13 @@ func main() {
 		}
 	}()
 
-	exporter := NewExporter(pgpool2Client)
-	if err := prometheus.Register(exporter); err != nil {
-		errChan <- err
+	exporter, err := NewExporter(pgpool2Client)
+	if err != nil {
+		logrus.Fatal(err)
 	}
+	logrus.Infof("Enabled collectors: %s", strings.Join(enabledCollectors(), ", "))
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle(*metricsPath, metricsHandler(exporter))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>` + exporterName + ` v` + version.Version + `</title></head>
@@ -3,24 +3,40 @@ package pgpool2
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	// http://www.pgpool.net/docs/latest/en/html/pcp-commands.html
-	PCPNodeCount    = "/usr/sbin/pcp_node_count"
-	PCPNodeInfo     = "/usr/sbin/pcp_node_info"
-	PCPProcCount    = "/usr/sbin/pcp_proc_count"
-	PCPProcInfo     = "/usr/sbin/pcp_proc_info"
-	PCPWatchdogInfo = "/usr/sbin/pcp_watchdog_info"
+	PCPNodeCount        = "/usr/sbin/pcp_node_count"
+	PCPNodeInfo         = "/usr/sbin/pcp_node_info"
+	PCPProcCount        = "/usr/sbin/pcp_proc_count"
+	PCPProcInfo         = "/usr/sbin/pcp_proc_info"
+	PCPWatchdogInfo     = "/usr/sbin/pcp_watchdog_info"
+	PCPHealthCheckStats = "/usr/sbin/pcp_health_check_stats"
+
+	// BackendExec runs the pcp_* binaries, BackendNative speaks the PCP
+	// protocol directly and BackendSQL runs SHOW commands on the pgpool
+	// frontend port instead of using PCP at all
+	BackendExec   = "exec"
+	BackendNative = "native"
+	BackendSQL    = "sql"
+
+	// PCPTimeLayout is the format of timestamps printed by the pcp_* binaries
+	PCPTimeLayout = "2006-01-02 15:04:05"
 
 	NodeStatusInitialization = "Initialization"
 	NodeStatusUP1            = "Node is up. No connections yet"
@@ -28,10 +44,27 @@ const (
 	NodeStatusDown           = "Node is down"
 	NodeStatusUnknown        = "Unknown node status"
 
+	// short node states as printed in "Status Name" by pcp_node_info
+	NodeStateUnused  = "unused"
+	NodeStateWaiting = "waiting"
+	NodeStateUp      = "up"
+	NodeStateDown    = "down"
+
+	NodeRolePrimary = "primary"
+	NodeRoleStandby = "standby"
+
+	WatchdogStateLeader  = "leader"
+	WatchdogStateStandby = "standby"
+
+	// watchdogNotSet is printed instead of the leader during an election
+	watchdogNotSet = "Not Set"
+
 	// do not reorder
 	// https://github.com/pgpool/pgpool2/blob/master/src/tools/pcp/pcp_frontend_client.c#L624
 	QuorumStateUnknown      = -3
 	QuorumStateNoMasterNode = -2
+	// pgpool-II 4.2 renamed master to leader
+	QuorumStateNoLeaderNode = QuorumStateNoMasterNode
 	QuorumStateAbsent       = -1
 	QuorumStateOnEdge       = 0
 	QuorumStateExist        = 1
@@ -47,9 +80,61 @@ var (
 		3: NodeStatusDown,
 	}
 
+	// NodeStates lists every node state, indexed by status code
+	NodeStates = []string{
+		NodeStateUnused,
+		NodeStateWaiting,
+		NodeStateUp,
+		NodeStateDown,
+	}
+
+	// NodeRoles lists the roles a node can have, the role names of other
+	// clustering modes are mapped onto them by NodeRoleToState
+	NodeRoles = []string{
+		NodeRolePrimary,
+		NodeRoleStandby,
+	}
+
+	// pgpool-II 4.2 renamed master/slave to main/replica
+	nodeRoleToState = map[string]string{
+		"primary": NodeRolePrimary,
+		"master":  NodeRolePrimary,
+		"main":    NodeRolePrimary,
+		"standby": NodeRoleStandby,
+		"slave":   NodeRoleStandby,
+		"replica": NodeRoleStandby,
+	}
+
+	// WatchdogStates lists the states of watchdog nodes
+	// https://github.com/pgpool/pgpool2/blob/master/src/include/watchdog/watchdog.h
+	WatchdogStates = []string{
+		"dead",
+		"loading",
+		"joining",
+		"initializing",
+		WatchdogStateLeader,
+		"participating_in_election",
+		"standing_for_leader",
+		WatchdogStateStandby,
+		"lost",
+		"in_network_trouble",
+		"shutdown",
+		"add_message_sent",
+	}
+
+	// QuorumStates maps every quorum state code to a label value
+	QuorumStates = map[int]string{
+		QuorumStateUnknown:      "unknown",
+		QuorumStateNoMasterNode: "no_master_node",
+		QuorumStateAbsent:       "absent",
+		QuorumStateOnEdge:       "on_edge",
+		QuorumStateExist:        "exist",
+	}
+
 	quorumStateToInt = map[string]int{
 		"UNKNOWN":               QuorumStateUnknown,
 		"NO MASTER NODE":        QuorumStateNoMasterNode,
+		"NO LEADER NODE":        QuorumStateNoLeaderNode,
 		"QUORUM ABSENT":         QuorumStateAbsent,
 		"QUORUM IS ON THE EDGE": QuorumStateOnEdge,
 		"QUORUM EXIST":          QuorumStateExist,
@@ -57,11 +142,28 @@ var (
 )
 
 type Options struct {
+	Backend  string
 	PassFile string
 	Hostname string
 	Port     int
 	Username string
 	Password string
+	// Timeout bounds every single PCP command, 0 disables it
+	Timeout time.Duration
+	// Location is the time zone of the timestamps formatted by pgpool,
+	// for every backend, nil means the local time zone
+	Location *time.Location
+	// Version overrides the detected pgpool release, e.g. "4.2.3"
+	Version string
+	// DSN is the connection string of the pgpool frontend for SHOW
+	// commands, required by BackendSQL
+	DSN string
+}
+
+// CommandName returns the name of a PCP command as used in metrics and
+// errors, e.g. "pcp_node_info".
+func CommandName(cmd string) string {
+	return filepath.Base(cmd)
 }
 
 type Client struct {
@@ -69,6 +171,21 @@ type Client struct {
 	pcpPassFile     string
 	pcpPassFileUser bool
 	pcpPassTempFile *os.File
+	pcpPassword     string
+	session         *pcpSession
+	sql             *SQLClient
+
+	versionMu sync.Mutex
+	version   Version
+	// versionErr is the last failed detection, returned until
+	// versionRetry instead of detecting again on every call
+	versionErr   error
+	versionRetry time.Time
+	// versionObserver is called after every detection attempt
+	versionObserver func(err error)
+
+	peersMu sync.Mutex
+	peers   []*Client
 }
 
 func NewClient(options Options) (*Client, error) {
@@ -82,12 +199,83 @@ func NewClient(options Options) (*Client, error) {
 	if err := client.Validate(); err != nil {
 		return nil, err
 	}
+	if len(client.options.Version) != 0 {
+		version, err := ParseVersion(client.options.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pgpool version: %v", err)
+		}
+		client.version = version
+	}
+	if len(client.options.DSN) != 0 {
+		sqlClient, err := NewSQLClient(client.options.DSN, client.options.Timeout, client.options.Location)
+		if err != nil {
+			return nil, err
+		}
+		client.sql = sqlClient
+	}
+	if client.options.Backend == BackendSQL {
+		return client, nil
+	}
+	if client.options.Backend == BackendNative {
+		if err := client.resolvePassword(); err != nil {
+			return nil, err
+		}
+		network, address := client.pcpAddress()
+		client.session = newPCPSession(
+			network,
+			address,
+			client.options.Username,
+			client.pcpPassword,
+		)
+		return client, nil
+	}
 	if err := client.createPCPTempFile(); err != nil {
 		return nil, err
 	}
+	// ServerVersion retries later if the binaries cannot tell yet
+	_, _ = client.ServerVersion(context.Background())
 	return client, nil
 }
 
+func (c *Client) resolvePassword() error {
+	if !c.pcpPassFileUser {
+		c.pcpPassword = c.options.Password
+		return nil
+	}
+	password, err := LookupPassFile(c.pcpPassFile, c.options.Hostname, c.options.Port, c.options.Username)
+	if err != nil && IsUnixSocketDir(c.options.Hostname) {
+		// like libpq, socket connections also match "localhost" entries
+		password, err = LookupPassFile(c.pcpPassFile, "localhost", c.options.Port, c.options.Username)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read password from pcppass: %v", err)
+	}
+	c.pcpPassword = password
+	return nil
+}
+
+func (c *Client) location() *time.Location {
+	if c.options.Location == nil {
+		return time.Local
+	}
+	return c.options.Location
+}
+
+// IsUnixSocketDir reports whether a PCP host refers to the directory of
+// pgpool's PCP Unix domain socket (pcp_socket_dir) rather than a hostname.
+func IsUnixSocketDir(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
+
+// pcpAddress returns the dial address of the PCP listener, which is
+// <dir>/.s.PGSQL.<port> for socket directories.
+func (c *Client) pcpAddress() (network, address string) {
+	if IsUnixSocketDir(c.options.Hostname) {
+		return "unix", filepath.Join(c.options.Hostname, fmt.Sprintf(".s.PGSQL.%d", c.options.Port))
+	}
+	return "tcp", net.JoinHostPort(c.options.Hostname, strconv.Itoa(c.options.Port))
+}
+
 func (c *Client) createPCPTempFile() error {
 	if c.pcpPassFileUser {
 		return nil
@@ -96,9 +284,13 @@ func (c *Client) createPCPTempFile() error {
 	if err != nil {
 		return err
 	}
+	passFileHost := c.options.Hostname
+	if IsUnixSocketDir(passFileHost) {
+		passFileHost = "*"
+	}
 	_, err = f.WriteString(fmt.Sprintf(
 		"%s:%d:%s:%s",
-		c.options.Hostname,
+		passFileHost,
 		c.options.Port,
 		c.options.Username,
 		c.options.Password,
@@ -115,7 +307,56 @@ func (c *Client) createPCPTempFile() error {
 	return nil
 }
 
+// Peer returns a client of another pgpool of the cluster at address
+// (host, host:port or a socket directory) using the same options and
+// credentials. It is cleaned together with c.
+func (c *Client) Peer(address string) (Source, error) {
+	if c.options.Backend == BackendSQL {
+		return nil, fmt.Errorf("peer %s: %w", address, ErrUnsupported)
+	}
+	options := c.options
+	// the DSN points at the local pgpool, the members of a watchdog
+	// cluster run the same release
+	options.DSN = ""
+	if len(options.Version) == 0 {
+		c.versionMu.Lock()
+		if !c.version.IsZero() {
+			options.Version = c.version.String()
+		}
+		c.versionMu.Unlock()
+	}
+	options.Hostname = address
+	if !IsUnixSocketDir(address) {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			options.Hostname = host
+			if options.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("invalid port of PCP peer %s: %v", address, err)
+			}
+		}
+	}
+	peer, err := NewClient(options)
+	if err != nil {
+		return nil, fmt.Errorf("PCP peer %s: %w", address, err)
+	}
+	c.peersMu.Lock()
+	c.peers = append(c.peers, peer)
+	c.peersMu.Unlock()
+	return peer, nil
+}
+
 func (c *Client) Clean() error {
+	c.peersMu.Lock()
+	for _, peer := range c.peers {
+		peer.Clean()
+	}
+	c.peers = nil
+	c.peersMu.Unlock()
+	if c.session != nil {
+		c.session.close()
+	}
+	if c.sql != nil {
+		c.sql.Close()
+	}
 	if c.pcpPassFileUser {
 		return nil
 	}
@@ -127,9 +368,31 @@ func (c *Client) Clean() error {
 }
 
 func (c *Client) Validate() error {
+	if len(c.options.Backend) == 0 {
+		c.options.Backend = BackendExec
+	}
+	switch c.options.Backend {
+	case BackendExec, BackendNative:
+	case BackendSQL:
+		if len(c.options.DSN) == 0 {
+			return errors.New("pgpool DSN must be specified for the sql backend")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown PCP backend %q", c.options.Backend)
+	}
 	if len(c.options.Hostname) == 0 {
 		return errors.New("PCP hostname must be specified")
 	}
+	if IsUnixSocketDir(c.options.Hostname) {
+		info, err := os.Stat(c.options.Hostname)
+		if err != nil {
+			return fmt.Errorf("cannot access PCP socket directory: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("PCP socket directory %s is not a directory", c.options.Hostname)
+		}
+	}
 	if len(c.options.Username) == 0 {
 		return errors.New("PCP username must be specified")
 	}
@@ -157,8 +420,35 @@ func (c *Client) Validate() error {
 	return nil
 }
 
-func (c *Client) execCommand(cmd string, arg ...string) (*bytes.Buffer, error) {
+// commandContext applies Options.Timeout to ctx.
+func (c *Client) commandContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.options.Timeout > 0 {
+		return context.WithTimeout(ctx, c.options.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// commandError wraps the failure of cmd into a *CommandError.
+func commandError(ctx context.Context, cmd string, err error, reason error, stderr string) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		reason = ErrTimeout
+	}
+	return &CommandError{
+		Command: CommandName(cmd),
+		Reason:  reason,
+		Stderr:  stderr,
+		Err:     err,
+	}
+}
+
+func (c *Client) execCommand(ctx context.Context, cmd string, arg ...string) (*bytes.Buffer, error) {
+	ctx, cancel := c.commandContext(ctx)
+	defer cancel()
 	stdoutBuffer := &bytes.Buffer{}
+	stderrBuffer := &bytes.Buffer{}
 	argCommon := []string{
 		fmt.Sprintf("--username=%s", c.options.Username),
 		fmt.Sprintf("--host=%s", c.options.Hostname),
@@ -167,20 +457,78 @@ func (c *Client) execCommand(cmd string, arg ...string) (*bytes.Buffer, error) {
 		"--no-password",
 	}
 	argResult := append(argCommon, arg...)
-	pgpoolExec := exec.Command(cmd, argResult...)
+	// the child is killed once ctx is done
+	pgpoolExec := exec.CommandContext(ctx, cmd, argResult...)
 	pgpoolExec.Env = []string{
 		fmt.Sprintf("PCPPASSFILE=%s", c.pcpPassFile),
 	}
 	pgpoolExec.Stdout = stdoutBuffer
+	pgpoolExec.Stderr = stderrBuffer
 	err := pgpoolExec.Run()
 	if err != nil {
-		return stdoutBuffer, err
+		stderr := redact(strings.TrimSpace(stderrBuffer.String()), c.options.Password)
+		return stdoutBuffer, commandError(ctx, cmd, err, classifyExecError(err, stderr), stderr)
 	}
 	return stdoutBuffer, nil
 }
 
-func (c *Client) ExecNodeCount() (int, error) {
-	bytesBuffer, err := c.execCommand(PCPNodeCount)
+// parseError wraps a failure to parse the output of cmd.
+func parseError(cmd string, err error) error {
+	return &CommandError{
+		Command: CommandName(cmd),
+		Reason:  ErrParse,
+		Err:     err,
+	}
+}
+
+// pcpCommand runs fn on the persistent PCP session.
+func (c *Client) pcpCommand(ctx context.Context, cmd string, fn func(conn *pcpConn) error) error {
+	ctx, cancel := c.commandContext(ctx)
+	defer cancel()
+	err := c.session.do(ctx, fn)
+	return commandError(ctx, cmd, err, classifyNativeError(err), "")
+}
+
+// SessionStats returns the state of the persistent PCP session; ok is
+// false for the exec backend which has no session.
+func (c *Client) SessionStats() (stats SessionStats, ok bool) {
+	if c.session == nil {
+		return SessionStats{}, false
+	}
+	return c.session.stats(), true
+}
+
+// Ping runs the cheapest PCP command, pcp_node_count, to check that pgpool
+// accepts our connection and credentials.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.options.Backend == BackendSQL {
+		_, err := c.sql.ShowPoolVersion(ctx)
+		return err
+	}
+	if c.options.Backend == BackendNative {
+		return c.pcpCommand(ctx, PCPNodeCount, func(conn *pcpConn) error {
+			_, err := conn.nodeCount()
+			return err
+		})
+	}
+	_, err := c.execCommand(ctx, PCPNodeCount)
+	return err
+}
+
+func (c *Client) ExecNodeCount(ctx context.Context) (int, error) {
+	if c.options.Backend == BackendSQL {
+		nodes, err := c.sql.ShowPoolNodes(ctx)
+		return len(nodes), err
+	}
+	if c.options.Backend == BackendNative {
+		var nodeCount int
+		err := c.pcpCommand(ctx, PCPNodeCount, func(conn *pcpConn) (err error) {
+			nodeCount, err = conn.nodeCount()
+			return err
+		})
+		return nodeCount, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPNodeCount)
 	if err != nil {
 		return 0, err
 	}
@@ -194,7 +542,7 @@ func (c *Client) ExecNodeCount() (int, error) {
 	}
 	resultInt, err := strconv.Atoi(resultString)
 	if err != nil {
-		return 0, err
+		return 0, parseError(PCPNodeCount, err)
 	}
 	return resultInt, nil
 }
@@ -210,6 +558,9 @@ type NodeInfo struct {
 	ReplicationState     string
 	ReplicationSyncState string
 	LastStatusChange     string
+	// LastStatusChangeTime is the parsed LastStatusChange, zero if pgpool
+	// did not report it
+	LastStatusChangeTime time.Time
 }
 
 func NodeStatusCodeToString(statusID int) string {
@@ -220,6 +571,32 @@ func NodeStatusCodeToString(statusID int) string {
 	return status
 }
 
+// NodeStatusCodeToState returns the short name of a status code, or an
+// empty string for unknown codes.
+func NodeStatusCodeToState(statusID int) string {
+	if statusID < 0 || statusID >= len(NodeStates) {
+		return ""
+	}
+	return NodeStates[statusID]
+}
+
+// NodeRoleToState returns the entry of NodeRoles matching role, or an
+// empty string for unknown roles.
+func NodeRoleToState(role string) string {
+	return nodeRoleToState[strings.ToLower(role)]
+}
+
+// SplitPCPLine splits a "Key : value" line printed by the pcp_* binaries
+// in verbose mode. The padding before the colon differs between pgpool
+// releases and is not part of the key.
+func SplitPCPLine(line string) (key, value string, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
+}
+
 func ExtractValueFromPCPString(line string) string {
 	valueArr := PCPValueRegExp.FindStringSubmatch(line)
 	if len(valueArr) > 0 {
@@ -228,92 +605,208 @@ func ExtractValueFromPCPString(line string) string {
 	return ""
 }
 
+// NodeInfoUnmarshal parses the output of pcp_node_info -v, reading
+// timestamps in the local time zone.
 func NodeInfoUnmarshal(cmdOutBuff io.Reader) (NodeInfo, error) {
+	return NodeInfoUnmarshalInLocation(cmdOutBuff, time.Local)
+}
+
+// NodeInfoUnmarshalInLocation parses the output of pcp_node_info -v,
+// reading timestamps in loc.
+func NodeInfoUnmarshalInLocation(cmdOutBuff io.Reader, loc *time.Location) (NodeInfo, error) {
 	var ni NodeInfo
 	reader := bufio.NewReader(cmdOutBuff)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF && len(line) == 0 {
 				break
-			} else {
+			} else if err != io.EOF {
 				return ni, err
 			}
 		}
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "Hostname") {
-			ni.Hostname = ExtractValueFromPCPString(line)
+		key, value, ok := SplitPCPLine(line)
+		if !ok {
+			continue
 		}
-		if strings.Contains(line, "Port") {
-			portRaw := ExtractValueFromPCPString(line)
-			portInt, err := strconv.Atoi(portRaw)
+		switch key {
+		case "Hostname":
+			ni.Hostname = value
+		case "Port":
+			portInt, err := strconv.Atoi(value)
 			if err != nil {
 				continue
 			}
 			ni.Port = portInt
-		}
-		// this has to be tested before "Status"
-		if strings.Contains(line, "Last Status Change") {
-			ni.LastStatusChange = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Status") {
-			statusRaw := ExtractValueFromPCPString(line)
-			statusInt, err := strconv.Atoi(statusRaw)
+		case "Status":
+			statusInt, err := strconv.Atoi(value)
 			if err != nil {
 				continue
 			}
 			ni.StatusCode = statusInt
 			ni.Status = NodeStatusCodeToString(statusInt)
-		}
-		if strings.Contains(line, "Weight") {
-			weightRaw := ExtractValueFromPCPString(line)
-			weightFloat, err := strconv.ParseFloat(weightRaw, 64)
+		case "Weight":
+			weightFloat, err := strconv.ParseFloat(value, 64)
 			if err != nil {
 				continue
 			}
 			ni.Weight = weightFloat
-		}
-		if strings.Contains(line, "Role") {
-			ni.Role = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Replication Delay") {
-			delayRaw := ExtractValueFromPCPString(line)
-			delayFloat, err := strconv.ParseFloat(delayRaw, 64)
+		case "Role":
+			// "Backend Role" of 4.3+ is what PostgreSQL reports, Role is
+			// what pgpool acts upon
+			ni.Role = value
+		case "Replication Delay":
+			delayFloat, err := strconv.ParseFloat(value, 64)
 			if err != nil {
 				continue
 			}
 			ni.ReplicationDelay = delayFloat
+		case "Replication State":
+			ni.ReplicationState = value
+		case "Replication Sync State":
+			ni.ReplicationSyncState = value
+		case "Last Status Change":
+			ni.LastStatusChange = value
+			lastStatusChange, err := time.ParseInLocation(PCPTimeLayout, value, loc)
+			if err == nil {
+				ni.LastStatusChangeTime = lastStatusChange
+			}
 		}
-		if strings.Contains(line, "Replication State") {
-			ni.ReplicationState = ExtractValueFromPCPString(line)
-		}
-		if strings.Contains(line, "Replication Sync State") {
-			ni.ReplicationSyncState = ExtractValueFromPCPString(line)
+		if err == io.EOF {
+			break
 		}
 	}
 	return ni, nil
 }
 
-func (c *Client) ExecNodeInfo(nodeID int) (NodeInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPNodeInfo, fmt.Sprintf("--node-id=%d", nodeID), "-v")
+// NodeInfoArrayUnmarshal parses the output of pcp_node_info -v for all
+// nodes, reading timestamps in the local time zone.
+func NodeInfoArrayUnmarshal(cmdOutBuff io.Reader) ([]NodeInfo, error) {
+	return NodeInfoArrayUnmarshalInLocation(cmdOutBuff, time.Local)
+}
+
+// NodeInfoArrayUnmarshalInLocation parses the output of pcp_node_info -v
+// for all nodes, reading timestamps in loc. Every node starts with its
+// Hostname line; the nodes are in the order of their ids.
+func NodeInfoArrayUnmarshalInLocation(cmdOutBuff io.Reader, loc *time.Location) ([]NodeInfo, error) {
+	var records []*bytes.Buffer
+	scanner := bufio.NewScanner(cmdOutBuff)
+	for scanner.Scan() {
+		line := scanner.Text()
+		key, _, ok := SplitPCPLine(line)
+		if ok && key == "Hostname" {
+			records = append(records, &bytes.Buffer{})
+		}
+		if len(records) == 0 {
+			continue
+		}
+		records[len(records)-1].WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	nodes := make([]NodeInfo, 0, len(records))
+	for _, record := range records {
+		ni, err := NodeInfoUnmarshalInLocation(record, loc)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, ni)
+	}
+	return nodes, nil
+}
+
+// sqlNodeInfo returns the nodes of SHOW POOL_NODES.
+func (c *Client) sqlNodeInfo(ctx context.Context) ([]NodeInfo, error) {
+	poolNodes, err := c.sql.ShowPoolNodes(ctx)
 	if err != nil {
-		return NodeInfo{}, err
+		return nil, err
+	}
+	nodes := make([]NodeInfo, len(poolNodes))
+	for i, pn := range poolNodes {
+		nodes[i] = pn.NodeInfo
+	}
+	return nodes, nil
+}
+
+// ExecAllNodeInfo returns every node with a single PCP command, which
+// requires FeatureAllNodeInfo. The nodes are indexed by node id.
+func (c *Client) ExecAllNodeInfo(ctx context.Context) ([]NodeInfo, error) {
+	if c.options.Backend == BackendSQL {
+		return c.sqlNodeInfo(ctx)
+	}
+	if c.options.Backend == BackendNative {
+		var nodes []NodeInfo
+		err := c.pcpCommand(ctx, PCPNodeInfo, func(conn *pcpConn) (err error) {
+			nodes, err = conn.allNodeInfo()
+			return err
+		})
+		return nodes, err
 	}
-	nodeInfo, err := NodeInfoUnmarshal(bytesBuffer)
+	bytesBuffer, err := c.execCommand(ctx, PCPNodeInfo, "-v")
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := NodeInfoArrayUnmarshalInLocation(bytesBuffer, c.location())
+	if err != nil {
+		return nil, parseError(PCPNodeInfo, err)
+	}
+	return nodes, nil
+}
+
+func (c *Client) ExecNodeInfo(ctx context.Context, nodeID int) (NodeInfo, error) {
+	if c.options.Backend == BackendSQL {
+		nodes, err := c.sqlNodeInfo(ctx)
+		if err != nil {
+			return NodeInfo{}, err
+		}
+		if nodeID < 0 || nodeID >= len(nodes) {
+			return NodeInfo{}, &CommandError{
+				Command: CommandName(SQLShowPoolNodes),
+				Reason:  ErrUnknownNode,
+				Err:     fmt.Errorf("node id %d does not exist", nodeID),
+			}
+		}
+		return nodes[nodeID], nil
+	}
+	if c.options.Backend == BackendNative {
+		var nodeInfo NodeInfo
+		err := c.pcpCommand(ctx, PCPNodeInfo, func(conn *pcpConn) (err error) {
+			nodeInfo, err = conn.nodeInfo(nodeID)
+			return err
+		})
+		return nodeInfo, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPNodeInfo, fmt.Sprintf("--node-id=%d", nodeID), "-v")
 	if err != nil {
 		return NodeInfo{}, err
 	}
+	nodeInfo, err := NodeInfoUnmarshalInLocation(bytesBuffer, c.location())
+	if err != nil {
+		return NodeInfo{}, parseError(PCPNodeInfo, err)
+	}
 	return nodeInfo, nil
 }
 
-func (c *Client) ExecProcInfo() ([]ProcInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPProcInfo, "--all")
+func (c *Client) ExecProcInfo(ctx context.Context) ([]ProcInfo, error) {
+	if c.options.Backend == BackendSQL {
+		return c.sql.ShowPoolPools(ctx)
+	}
+	if c.options.Backend == BackendNative {
+		var procInfoArr []ProcInfo
+		err := c.pcpCommand(ctx, PCPProcInfo, func(conn *pcpConn) (err error) {
+			procInfoArr, err = conn.procInfo(c.location())
+			return err
+		})
+		return procInfoArr, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPProcInfo, "--all", "-v")
 	if err != nil {
 		return []ProcInfo{}, err
 	}
-	procInfoArr, err := ProcInfoUnmarshal(bytesBuffer)
+	procInfoArr, err := ProcInfoUnmarshalInLocation(bytesBuffer, c.location())
 	if err != nil {
-		return []ProcInfo{}, err
+		return []ProcInfo{}, parseError(PCPProcInfo, err)
 	}
 	return procInfoArr, nil
 }
@@ -346,16 +839,36 @@ func (p *ProcInfoSummary) Add(database string, active bool) {
 	p.Inactive[database]++
 }
 
-func (c *Client) ProcInfoSummary(pi []ProcInfo) ProcInfoSummary {
+// SummarizeProcInfo counts the pool slots by database. Slots without a
+// database have never been used and are left out.
+func SummarizeProcInfo(pi []ProcInfo) ProcInfoSummary {
 	summary := NewProcInfoSummary()
 	for _, procInfo := range pi {
+		if len(procInfo.Database) == 0 {
+			continue
+		}
 		summary.Add(procInfo.Database, procInfo.Connected)
 	}
 	return summary
 }
 
-func (c *Client) ExecProcCount() ([]string, error) {
-	bytesBuffer, err := c.execCommand(PCPProcCount)
+func (c *Client) ProcInfoSummary(pi []ProcInfo) ProcInfoSummary {
+	return SummarizeProcInfo(pi)
+}
+
+func (c *Client) ExecProcCount(ctx context.Context) ([]string, error) {
+	if c.options.Backend == BackendSQL {
+		return c.sql.ShowPoolProcesses(ctx)
+	}
+	if c.options.Backend == BackendNative {
+		var procCountArr []string
+		err := c.pcpCommand(ctx, PCPProcCount, func(conn *pcpConn) (err error) {
+			procCountArr, err = conn.procCount()
+			return err
+		})
+		return procCountArr, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPProcCount)
 	if err != nil {
 		return []string{}, err
 	}
@@ -368,14 +881,30 @@ func (c *Client) ExecProcCount() ([]string, error) {
 	return procCountArr, nil
 }
 
-func (c *Client) ExecWatchdogInfo() (WatchdogInfo, error) {
-	bytesBuffer, err := c.execCommand(PCPWatchdogInfo, "-v")
+func (c *Client) ExecWatchdogInfo(ctx context.Context) (WatchdogInfo, error) {
+	if c.options.Backend == BackendSQL {
+		// there is no SHOW command for the watchdog
+		return WatchdogInfo{}, &CommandError{
+			Command: CommandName(PCPWatchdogInfo),
+			Reason:  ErrUnsupported,
+			Err:     errors.New("the sql backend cannot query the watchdog"),
+		}
+	}
+	if c.options.Backend == BackendNative {
+		var watchdogInfo WatchdogInfo
+		err := c.pcpCommand(ctx, PCPWatchdogInfo, func(conn *pcpConn) (err error) {
+			watchdogInfo, err = conn.watchdogInfo()
+			return err
+		})
+		return watchdogInfo, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPWatchdogInfo, "-v")
 	if err != nil {
 		return WatchdogInfo{}, err
 	}
 	watchdogInfo, err := WatchdogInfoUnmarshal(bytesBuffer)
 	if err != nil {
-		return WatchdogInfo{}, err
+		return WatchdogInfo{}, parseError(PCPWatchdogInfo, err)
 	}
 	return watchdogInfo, nil
 }
@@ -387,6 +916,86 @@ type WatchdogInfo struct {
 	QuorumStateCode  int
 	AliveRemoteNodes int
 	VIP              bool
+	// LeaderNodeName and LeaderHostName identify the leader of the
+	// cluster, called master before pgpool-II 4.2; both are empty while
+	// there is none
+	LeaderNodeName string
+	LeaderHostName string
+	// Membership is set if pgpool reported the fields below, which were
+	// added in pgpool-II 4.3
+	Membership          bool
+	MemberRemoteNodes   int
+	QuorumNodesRequired int
+	// Nodes are the members of the watchdog cluster, starting with the
+	// local node
+	Nodes []WatchdogNode
+}
+
+// IsLeader reports whether the local node is the watchdog leader.
+func (wi WatchdogInfo) IsLeader() bool {
+	return len(wi.Nodes) != 0 && WatchdogNodeState(wi.Nodes[0].StatusName) == WatchdogStateLeader
+}
+
+// WatchdogNode is a member of the watchdog cluster.
+type WatchdogNode struct {
+	NodeName     string
+	HostName     string
+	DelegateIP   string
+	PgpoolPort   int
+	WatchdogPort int
+	Priority     int
+	Status       int
+	StatusName   string
+	// MembershipStatus is only reported by pgpool-II 4.3+
+	MembershipStatus string
+}
+
+// set assigns the value printed by pcp_watchdog_info -v for key, values
+// which cannot be parsed are skipped.
+func (wn *WatchdogNode) set(key, value string) {
+	switch key {
+	case "Node Name":
+		wn.NodeName = value
+	case "Host Name":
+		wn.HostName = value
+	case "Delegate IP":
+		wn.DelegateIP = value
+	case "Pgpool port":
+		wn.PgpoolPort, _ = strconv.Atoi(value)
+	case "Watchdog port":
+		wn.WatchdogPort, _ = strconv.Atoi(value)
+	case "Node priority":
+		wn.Priority, _ = strconv.Atoi(value)
+	case "Status":
+		wn.Status, _ = strconv.Atoi(value)
+	case "Status Name":
+		wn.StatusName = value
+	case "Membership Status":
+		wn.MembershipStatus = value
+	}
+}
+
+// WatchdogNodeState returns the entry of WatchdogStates matching the status
+// name of a watchdog node, or an empty string for unknown statuses.
+func WatchdogNodeState(statusName string) string {
+	state := strings.Replace(strings.ToLower(strings.TrimSpace(statusName)), " ", "_", -1)
+	// pgpool-II 4.2 renamed master to leader
+	state = strings.Replace(state, "master", "leader", -1)
+	for _, s := range WatchdogStates {
+		if s == state {
+			return s
+		}
+	}
+	return ""
+}
+
+// watchdogLeaderValue returns the leader name or host printed by pgpool,
+// or an empty string for the "Not Set" printed during an election.
+func watchdogLeaderValue(value string) string {
+	if value == watchdogNotSet {
+		return ""
+	}
+	return value
 }
 
 func QuorumStateToCode(state string) int {
@@ -396,87 +1005,85 @@ func QuorumStateToCode(state string) int {
 	return QuorumStateUnknown
 }
 
+// WatchdogInfoUnmarshal parses the output of pcp_watchdog_info -v, in the
+// vocabulary of pgpool-II 3.7 up to 4.4.
 func WatchdogInfoUnmarshal(cmdOutBuff io.Reader) (WatchdogInfo, error) {
 	var wi WatchdogInfo
+	nodes := false
 	reader := bufio.NewReader(cmdOutBuff)
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF && len(line) == 0 {
 				break
-			} else {
+			} else if err != io.EOF {
 				return wi, err
 			}
 		}
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "Total Nodes") {
-			totalNodesRaw := ExtractValueFromPCPString(line)
-			totalNodesInt, err := strconv.Atoi(totalNodesRaw)
+		if strings.HasPrefix(line, "Watchdog Node Information") {
+			// the per node sections follow, each starting with Node Name
+			nodes = true
+			continue
+		}
+		key, value, ok := SplitPCPLine(line)
+		if !ok {
+			continue
+		}
+		if nodes {
+			if key == "Node Name" {
+				wi.Nodes = append(wi.Nodes, WatchdogNode{})
+			}
+			if len(wi.Nodes) != 0 {
+				wi.Nodes[len(wi.Nodes)-1].set(key, value)
+			}
+			continue
+		}
+		switch key {
+		case "Total Nodes":
+			totalNodesInt, err := strconv.Atoi(value)
 			if err != nil {
 				continue
 			}
 			wi.TotalNodes = totalNodesInt
-		}
-		if strings.Contains(line, "Remote Nodes") {
-			remoteNodesRaw := ExtractValueFromPCPString(line)
-			remoteNodesInt, err := strconv.Atoi(remoteNodesRaw)
+		case "Remote Nodes":
+			remoteNodesInt, err := strconv.Atoi(value)
 			if err != nil {
 				continue
 			}
 			wi.RemoteNodes = remoteNodesInt
-		}
-		if strings.Contains(line, "Quorum state") {
-			wi.QuorumState = ExtractValueFromPCPString(line)
-			wi.QuorumStateCode = QuorumStateToCode(wi.QuorumState)
-		}
-		if strings.Contains(line, "Alive Remote Nodes") {
-			aliveRemoteNodesRaw := ExtractValueFromPCPString(line)
-			aliveRemoteNodesInt, err := strconv.Atoi(aliveRemoteNodesRaw)
+		case "Member Remote Nodes":
+			memberRemoteNodesInt, err := strconv.Atoi(value)
 			if err != nil {
 				continue
 			}
-			wi.AliveRemoteNodes = aliveRemoteNodesInt
-		}
-		if strings.Contains(line, "VIP up on local node") {
-			vipRaw := ExtractValueFromPCPString(line)
-			if vipRaw == "YES" {
-				wi.VIP = true
+			wi.MemberRemoteNodes = memberRemoteNodesInt
+			wi.Membership = true
+		case "Alive Remote Nodes":
+			aliveRemoteNodesInt, err := strconv.Atoi(value)
+			if err != nil {
+				continue
 			}
-		}
-	}
-	return wi, nil
-}
-
-type ProcInfo struct {
-	Database  string
-	Username  string
-	Connected bool
-}
-
-func ProcInfoUnmarshal(cmdOutBuff io.Reader) ([]ProcInfo, error) {
-	var pi []ProcInfo
-	reader := bufio.NewReader(cmdOutBuff)
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return pi, err
+			wi.AliveRemoteNodes = aliveRemoteNodesInt
+		case "Nodes required for quorum":
+			quorumNodesInt, err := strconv.Atoi(value)
+			if err != nil {
+				continue
 			}
+			wi.QuorumNodesRequired = quorumNodesInt
+			wi.Membership = true
+		case "Quorum state":
+			wi.QuorumState = value
+			wi.QuorumStateCode = QuorumStateToCode(wi.QuorumState)
+		case "VIP up on local node", "Local node escalation":
+			wi.VIP = value == "YES"
+		case "Master Node Name", "Leader Node Name":
+			wi.LeaderNodeName = watchdogLeaderValue(value)
+		case "Master Host Name", "Leader Host Name":
+			wi.LeaderHostName = watchdogLeaderValue(value)
 		}
-		line = strings.TrimSpace(line)
-		connectionInfo := strings.Split(line, " ")
-		if len(connectionInfo) == 13 {
-			procInfo := ProcInfo{
-				Database: connectionInfo[0],
-				Username: connectionInfo[1],
-			}
-			if connectionInfo[12] == "1" {
-				procInfo.Connected = true
-			}
-			pi = append(pi, procInfo)
+		if err == io.EOF {
+			break
 		}
 	}
-	return pi, nil
+	return wi, nil
 }
@@ -0,0 +1,161 @@
+package pgpool2
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// openFixture opens the output of a pcp_* binary of a pgpool release,
+// recorded in testdata/<version>/<name>.txt.
+func openFixture(t *testing.T, version, name string) *os.File {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", version, name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestNodeInfoUnmarshalInLocation(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	// pgpool-II 3.7 and 4.0 print neither the replication state nor
+	// the last status change
+	legacy := NodeInfo{
+		Hostname:         "pg1",
+		Port:             5432,
+		StatusCode:       2,
+		Status:           NodeStatusUP2,
+		Weight:           0.5,
+		Role:             "standby",
+		ReplicationDelay: 1024,
+	}
+	current := legacy
+	current.ReplicationState = "streaming"
+	current.ReplicationSyncState = "async"
+	current.LastStatusChange = "2021-02-27 15:10:19"
+	current.LastStatusChangeTime = time.Date(2021, 2, 27, 15, 10, 19, 0, loc)
+
+	tests := []struct {
+		version string
+		want    NodeInfo
+	}{
+		{"3.7", legacy},
+		{"4.0", legacy},
+		{"4.1", current},
+		{"4.2", current},
+		// Backend Status Name and Backend Role are not read
+		{"4.3", current},
+		{"4.4", current},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := NodeInfoUnmarshalInLocation(openFixture(t, tt.version, "pcp_node_info"), loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatchdogInfoUnmarshal(t *testing.T) {
+	twoNodes := func(leaderStatus string) WatchdogInfo {
+		return WatchdogInfo{
+			TotalNodes:       2,
+			RemoteNodes:      1,
+			QuorumState:      "QUORUM EXIST",
+			QuorumStateCode:  QuorumStateExist,
+			AliveRemoteNodes: 1,
+			VIP:              true,
+			LeaderNodeName:   "pgpool0:9999 Linux pgpool0",
+			LeaderHostName:   "pgpool0",
+			Nodes: []WatchdogNode{
+				{NodeName: "pgpool0:9999 Linux pgpool0", HostName: "pgpool0", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 2, Status: 4, StatusName: leaderStatus},
+				{NodeName: "pgpool1:9999 Linux pgpool1", HostName: "pgpool1", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 1, Status: 7, StatusName: "STANDBY"},
+			},
+		}
+	}
+	membership := WatchdogInfo{
+		TotalNodes:          3,
+		RemoteNodes:         2,
+		QuorumState:         "QUORUM EXIST",
+		QuorumStateCode:     QuorumStateExist,
+		AliveRemoteNodes:    1,
+		VIP:                 true,
+		LeaderNodeName:      "pgpool0:9999 Linux pgpool0",
+		LeaderHostName:      "pgpool0",
+		Membership:          true,
+		MemberRemoteNodes:   2,
+		QuorumNodesRequired: 2,
+		Nodes: []WatchdogNode{
+			{NodeName: "pgpool0:9999 Linux pgpool0", HostName: "pgpool0", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 3, Status: 4, StatusName: "LEADER", MembershipStatus: "MEMBER"},
+			{NodeName: "pgpool1:9999 Linux pgpool1", HostName: "pgpool1", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 2, Status: 7, StatusName: "STANDBY", MembershipStatus: "MEMBER"},
+			{NodeName: "pgpool2:9999 Linux pgpool2", HostName: "pgpool2", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 1, Status: 8, StatusName: "LOST", MembershipStatus: "MEMBER"},
+		},
+	}
+	noLeader := WatchdogInfo{
+		TotalNodes:      2,
+		RemoteNodes:     1,
+		QuorumState:     "NO LEADER NODE",
+		QuorumStateCode: QuorumStateNoLeaderNode,
+		Nodes: []WatchdogNode{
+			{NodeName: "pgpool1:9999 Linux pgpool1", HostName: "pgpool1", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 1, Status: 6, StatusName: "STANDING FOR LEADER"},
+			{NodeName: "pgpool0:9999 Linux pgpool0", HostName: "pgpool0", DelegateIP: "10.0.0.100", PgpoolPort: 9999, WatchdogPort: 9000, Priority: 2, Status: 8, StatusName: "LOST"},
+		},
+	}
+
+	tests := []struct {
+		version    string
+		name       string
+		want       WatchdogInfo
+		wantLeader bool
+	}{
+		{"3.7", "pcp_watchdog_info", twoNodes("MASTER"), true},
+		{"4.0", "pcp_watchdog_info", twoNodes("MASTER"), true},
+		{"4.1", "pcp_watchdog_info", twoNodes("MASTER"), true},
+		// Leader Node Name replaced Master Node Name
+		{"4.2", "pcp_watchdog_info", twoNodes("LEADER"), true},
+		{"4.2", "pcp_watchdog_info_no_leader", noLeader, false},
+		// Local node escalation replaced VIP up on local node, Member
+		// Remote Nodes and Nodes required for quorum were added
+		{"4.3", "pcp_watchdog_info", membership, true},
+		{"4.4", "pcp_watchdog_info", membership, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"/"+tt.name, func(t *testing.T) {
+			got, err := WatchdogInfoUnmarshal(openFixture(t, tt.version, tt.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if got.IsLeader() != tt.wantLeader {
+				t.Errorf("IsLeader() = %v, want %v", got.IsLeader(), tt.wantLeader)
+			}
+		})
+	}
+}
+
+func TestQuorumStateToCode(t *testing.T) {
+	tests := map[string]int{
+		"QUORUM EXIST":          QuorumStateExist,
+		"QUORUM IS ON THE EDGE": QuorumStateOnEdge,
+		"QUORUM ABSENT":         QuorumStateAbsent,
+		"NO MASTER NODE":        QuorumStateNoMasterNode,
+		"NO LEADER NODE":        QuorumStateNoLeaderNode,
+		"UNKNOWN":               QuorumStateUnknown,
+		"SOMETHING NEW":         QuorumStateUnknown,
+	}
+	for state, want := range tests {
+		if got := QuorumStateToCode(state); got != want {
+			t.Errorf("QuorumStateToCode(%q) = %d, want %d", state, got, want)
+		}
+	}
+}
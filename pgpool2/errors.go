@@ -0,0 +1,139 @@
+package pgpool2
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// Failure reasons of PCP commands, check for them with errors.Is.
+var (
+	ErrAuthentication    = errors.New("PCP authentication failed")
+	ErrConnectionRefused = errors.New("PCP connection refused")
+	ErrUnknownNode       = errors.New("unknown node id")
+	ErrNotRunning        = errors.New("pgpool is not running")
+	ErrBinaryMissing     = errors.New("PCP binary missing")
+	ErrTimeout           = errors.New("PCP command timed out")
+	ErrParse             = errors.New("cannot parse PCP output")
+	// ErrUnsupported is returned by sources which cannot provide the data,
+	// e.g. the watchdog state over SQL
+	ErrUnsupported = errors.New("not supported by the data source")
+)
+
+var (
+	errorReasons = []struct {
+		err    error
+		reason string
+	}{
+		{ErrAuthentication, "authentication"},
+		{ErrConnectionRefused, "connection_refused"},
+		{ErrUnknownNode, "unknown_node"},
+		{ErrNotRunning, "not_running"},
+		{ErrBinaryMissing, "binary_missing"},
+		{ErrTimeout, "timeout"},
+		{ErrParse, "parse"},
+		{ErrUnsupported, "unsupported"},
+	}
+
+	// messages of pcp_frontend_client and pgpool's PCP worker
+	errorPatterns = []struct {
+		re     *regexp.Regexp
+		reason error
+	}{
+		{regexp.MustCompile(`(?i)authentication failed|username and/or password|invalid password|no password supplied`), ErrAuthentication},
+		{regexp.MustCompile(`(?i)connection refused`), ErrConnectionRefused},
+		{regexp.MustCompile(`(?i)no such file or directory|is the server running`), ErrNotRunning},
+		{regexp.MustCompile(`(?i)(invalid|unknown) node id|node id \S+ (is not valid|does not exist|is out of range)`), ErrUnknownNode},
+	}
+
+	secretRegExp = regexp.MustCompile(`(?i)(password\s*[=:]\s*)\S+`)
+)
+
+// CommandError is the error returned by a failed PCP command. Reason is
+// one of the Err* values, or nil if the failure could not be classified.
+type CommandError struct {
+	Command string
+	Reason  error
+	Stderr  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("%s: %v", e.Command, e.Err)
+	if len(e.Stderr) != 0 {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) Is(target error) bool {
+	return e.Reason != nil && e.Reason == target
+}
+
+// ErrorReason returns a label value describing why a PCP command failed.
+func ErrorReason(err error) string {
+	for _, r := range errorReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+func parseErrorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrParse, fmt.Sprintf(format, a...))
+}
+
+// classifyMessage maps a pgpool error message to a failure reason.
+func classifyMessage(msg string) error {
+	for _, p := range errorPatterns {
+		if p.re.MatchString(msg) {
+			return p.reason
+		}
+	}
+	return nil
+}
+
+func classifyExecError(err error, stderr string) error {
+	var pathErr *os.PathError
+	if errors.Is(err, exec.ErrNotFound) || errors.As(err, &pathErr) {
+		return ErrBinaryMissing
+	}
+	return classifyMessage(stderr)
+}
+
+func classifyNativeError(err error) error {
+	var responseErr *pcpResponseError
+	switch {
+	case errors.Is(err, ErrAuthentication):
+		return ErrAuthentication
+	case errors.Is(err, ErrParse):
+		return ErrParse
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrConnectionRefused
+	case errors.Is(err, syscall.ENOENT):
+		// the PCP socket file does not exist
+		return ErrNotRunning
+	case errors.As(err, &responseErr):
+		return classifyMessage(responseErr.Message + " " + responseErr.Detail)
+	}
+	return nil
+}
+
+// redact removes secrets from text captured from the pcp_* binaries.
+func redact(text string, secrets ...string) string {
+	for _, secret := range secrets {
+		if len(secret) != 0 {
+			text = strings.Replace(text, secret, "<redacted>", -1)
+		}
+	}
+	return secretRegExp.ReplaceAllString(text, "${1}<redacted>")
+}
@@ -0,0 +1,157 @@
+package pgpool2
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorReason(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	execError := func(stderr string) error {
+		return commandError(context.Background(), PCPNodeCount, exitErr, classifyExecError(exitErr, stderr), stderr)
+	}
+	startError := func(err error) error {
+		return commandError(context.Background(), PCPNodeCount, err, classifyExecError(err, ""), "")
+	}
+	nativeError := func(err error) error {
+		return commandError(context.Background(), PCPNodeCount, err, classifyNativeError(err), "")
+	}
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "exec authentication",
+			err:  execError(`FATAL:  authentication failed for user "pcpadmin"` + "\nDETAIL:  username and/or password does not match"),
+			want: "authentication",
+		},
+		{
+			name: "native authentication",
+			err:  nativeError(&pcpResponseError{Severity: "FATAL", Message: "authentication failed for user \"pcpadmin\""}),
+			want: "authentication",
+		},
+		{
+			name: "exec connection refused",
+			err:  execError(`ERROR: connection to host "localhost" failed with error "Connection refused"`),
+			want: "connection_refused",
+		},
+		{
+			name: "native connection refused",
+			err:  nativeError(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}),
+			want: "connection_refused",
+		},
+		{
+			name: "exec unknown node",
+			err:  execError("ERROR: node id 5 is not valid"),
+			want: "unknown_node",
+		},
+		{
+			name: "native unknown node",
+			err:  nativeError(&pcpResponseError{Severity: "ERROR", Message: "invalid node id 5"}),
+			want: "unknown_node",
+		},
+		{
+			name: "exec not running",
+			err:  execError(`ERROR: connection to socket "/tmp/.s.PGSQL.9898" failed with error "No such file or directory"`),
+			want: "not_running",
+		},
+		{
+			name: "native not running",
+			err:  nativeError(&net.OpError{Op: "dial", Net: "unix", Err: os.NewSyscallError("connect", syscall.ENOENT)}),
+			want: "not_running",
+		},
+		{
+			name: "binary missing from PATH",
+			err:  startError(&exec.Error{Name: PCPNodeCount, Err: exec.ErrNotFound}),
+			want: "binary_missing",
+		},
+		{
+			name: "binary missing from the bin dir",
+			err:  startError(&os.PathError{Op: "fork/exec", Path: "/usr/bin/" + PCPNodeCount, Err: syscall.ENOENT}),
+			want: "binary_missing",
+		},
+		{
+			// the deadline overrides the classification of the message
+			name: "timeout",
+			err:  commandError(expired, PCPNodeCount, errors.New("signal: killed"), ErrConnectionRefused, ""),
+			want: "timeout",
+		},
+		{
+			name: "parse",
+			err:  nativeError(parseErrorf("missing node count in PCP response")),
+			want: "parse",
+		},
+		{
+			name: "unsupported",
+			err:  ErrUnsupported,
+			want: "unsupported",
+		},
+		{
+			name: "other",
+			err:  execError("ERROR: out of memory"),
+			want: "other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorReason(tt.err); got != tt.want {
+				t.Errorf("ErrorReason(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		secrets []string
+		want    string
+	}{
+		{
+			name:    "secret",
+			text:    `FATAL: authentication failed for "s3cr3t"`,
+			secrets: []string{"s3cr3t"},
+			want:    `FATAL: authentication failed for "<redacted>"`,
+		},
+		{
+			name:    "every occurrence",
+			text:    "s3cr3t s3cr3t",
+			secrets: []string{"s3cr3t"},
+			want:    "<redacted> <redacted>",
+		},
+		{
+			name:    "empty secret",
+			text:    "no password supplied",
+			secrets: []string{""},
+			want:    "no password supplied",
+		},
+		{
+			name: "password assignment",
+			text: "pcp_node_count --password=s3cr3t",
+			want: "pcp_node_count --password=<redacted>",
+		},
+		{
+			name: "password field",
+			text: "Password: s3cr3t",
+			want: "Password: <redacted>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redact(tt.text, tt.secrets...); got != tt.want {
+				t.Errorf("redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package pgpool2
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FakeSource is an in-memory Source returning canned data, meant for
+// tests of Source consumers.
+type FakeSource struct {
+	Nodes       []NodeInfo
+	Procs       []string
+	ProcInfo    []ProcInfo
+	Watchdog    WatchdogInfo
+	HealthCheck []HealthCheckStats
+	PoolNodes   []PoolNode
+	Backends    []BackendStats
+	PoolCache   PoolCache
+	Version     Version
+	// Peers are returned by Peer, keyed by address
+	Peers map[string]*FakeSource
+	// Delay is added to every call to simulate the latency of pgpool
+	Delay time.Duration
+
+	PingErr         error
+	NodeCountErr    error
+	NodeInfoErr     map[int]error
+	AllNodeInfoErr  error
+	ProcCountErr    error
+	ProcInfoErr     error
+	WatchdogInfoErr error
+	HealthCheckErr  map[int]error
+	PoolNodesErr    error
+	BackendsErr     error
+	PoolCacheErr    error
+	VersionErr      error
+}
+
+var _ Source = (*FakeSource)(nil)
+
+func (f *FakeSource) wait(ctx context.Context) error {
+	if f.Delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(f.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *FakeSource) Ping(ctx context.Context) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+	return f.PingErr
+}
+
+func (f *FakeSource) ExecNodeCount(ctx context.Context) (int, error) {
+	if err := f.wait(ctx); err != nil {
+		return 0, err
+	}
+	if f.NodeCountErr != nil {
+		return 0, f.NodeCountErr
+	}
+	return len(f.Nodes), nil
+}
+
+func (f *FakeSource) ExecNodeInfo(ctx context.Context, nodeID int) (NodeInfo, error) {
+	if err := f.wait(ctx); err != nil {
+		return NodeInfo{}, err
+	}
+	if err, ok := f.NodeInfoErr[nodeID]; ok {
+		return NodeInfo{}, err
+	}
+	if nodeID < 0 || nodeID >= len(f.Nodes) {
+		return NodeInfo{}, fmt.Errorf("node id %d out of range", nodeID)
+	}
+	return f.Nodes[nodeID], nil
+}
+
+func (f *FakeSource) ExecAllNodeInfo(ctx context.Context) ([]NodeInfo, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	if f.AllNodeInfoErr != nil {
+		return nil, f.AllNodeInfoErr
+	}
+	return f.Nodes, nil
+}
+
+func (f *FakeSource) ExecProcCount(ctx context.Context) ([]string, error) {
+	if err := f.wait(ctx); err != nil {
+		return []string{}, err
+	}
+	if f.ProcCountErr != nil {
+		return []string{}, f.ProcCountErr
+	}
+	return f.Procs, nil
+}
+
+func (f *FakeSource) ExecProcInfo(ctx context.Context) ([]ProcInfo, error) {
+	if err := f.wait(ctx); err != nil {
+		return []ProcInfo{}, err
+	}
+	if f.ProcInfoErr != nil {
+		return []ProcInfo{}, f.ProcInfoErr
+	}
+	return f.ProcInfo, nil
+}
+
+func (f *FakeSource) ExecWatchdogInfo(ctx context.Context) (WatchdogInfo, error) {
+	if err := f.wait(ctx); err != nil {
+		return WatchdogInfo{}, err
+	}
+	if f.WatchdogInfoErr != nil {
+		return WatchdogInfo{}, f.WatchdogInfoErr
+	}
+	return f.Watchdog, nil
+}
+
+func (f *FakeSource) ExecHealthCheckStats(ctx context.Context, nodeID int) (HealthCheckStats, error) {
+	if err := f.wait(ctx); err != nil {
+		return HealthCheckStats{}, err
+	}
+	if err, ok := f.HealthCheckErr[nodeID]; ok {
+		return HealthCheckStats{}, err
+	}
+	if nodeID < 0 || nodeID >= len(f.HealthCheck) {
+		return HealthCheckStats{}, fmt.Errorf("node id %d out of range", nodeID)
+	}
+	return f.HealthCheck[nodeID], nil
+}
+
+func (f *FakeSource) ExecPoolNodes(ctx context.Context) ([]PoolNode, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.PoolNodes, f.PoolNodesErr
+}
+
+func (f *FakeSource) ExecPoolCache(ctx context.Context) (PoolCache, error) {
+	if err := f.wait(ctx); err != nil {
+		return PoolCache{}, err
+	}
+	return f.PoolCache, f.PoolCacheErr
+}
+
+func (f *FakeSource) ExecBackendStats(ctx context.Context) ([]BackendStats, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	return f.Backends, f.BackendsErr
+}
+
+func (f *FakeSource) ServerVersion(ctx context.Context) (Version, error) {
+	if f.VersionErr != nil {
+		return Version{}, f.VersionErr
+	}
+	return f.Version, nil
+}
+
+func (f *FakeSource) Peer(address string) (Source, error) {
+	peer, ok := f.Peers[address]
+	if !ok {
+		return nil, fmt.Errorf("unknown peer %s", address)
+	}
+	return peer, nil
+}
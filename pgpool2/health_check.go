@@ -0,0 +1,193 @@
+package pgpool2
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// healthCheckStatsKeys are the keys printed by pcp_health_check_stats -v,
+// in the order of the fields of the PCP response
+var healthCheckStatsKeys = []string{
+	"Node Id",
+	"Host Name",
+	"Port",
+	"Status",
+	"Role",
+	"Last Status Change",
+	"Total Count",
+	"Success Count",
+	"Fail Count",
+	"Skip Count",
+	"Retry Count",
+	"Average Retry Count",
+	"Max Retry Count",
+	"Max Health Check Duration",
+	"Minimum Health Check Duration",
+	"Average Health Check Duration",
+	"Last Health Check",
+	"Last Successful Health Check",
+	"Last Skip Health Check",
+	"Last Failed Health Check",
+}
+
+// HealthCheckStats are the statistics of pgpool's health checks of one
+// backend node. Timestamps are zero if the event never happened.
+type HealthCheckStats struct {
+	NodeID           int
+	Hostname         string
+	Port             int
+	Status           string
+	Role             string
+	LastStatusChange time.Time
+
+	TotalCount        int64
+	SuccessCount      int64
+	FailCount         int64
+	SkipCount         int64
+	RetryCount        int64
+	AverageRetryCount float64
+	MaxRetryCount     int64
+
+	MaxDuration     time.Duration
+	MinDuration     time.Duration
+	AverageDuration time.Duration
+
+	LastHealthCheck           time.Time
+	LastSuccessfulHealthCheck time.Time
+	LastSkipHealthCheck       time.Time
+	LastFailedHealthCheck     time.Time
+}
+
+// set assigns the value printed for key, reading timestamps in loc.
+func (hs *HealthCheckStats) set(key, value string, loc *time.Location) error {
+	var err error
+	parseInt := func() int64 {
+		var i int64
+		i, err = strconv.ParseInt(value, 10, 64)
+		return i
+	}
+	parseFloat := func() float64 {
+		var f float64
+		f, err = strconv.ParseFloat(value, 64)
+		return f
+	}
+	// durations are printed in milliseconds
+	parseDuration := func() time.Duration {
+		return time.Duration(parseFloat() * float64(time.Millisecond))
+	}
+	parseTime := func() time.Time {
+		if len(value) == 0 {
+			return time.Time{}
+		}
+		var t time.Time
+		t, err = time.ParseInLocation(PCPTimeLayout, value, loc)
+		return t
+	}
+	switch key {
+	case "Node Id":
+		hs.NodeID = int(parseInt())
+	case "Host Name":
+		hs.Hostname = value
+	case "Port":
+		hs.Port = int(parseInt())
+	case "Status":
+		hs.Status = value
+	case "Role":
+		hs.Role = value
+	case "Last Status Change":
+		hs.LastStatusChange = parseTime()
+	case "Total Count":
+		hs.TotalCount = parseInt()
+	case "Success Count":
+		hs.SuccessCount = parseInt()
+	case "Fail Count":
+		hs.FailCount = parseInt()
+	case "Skip Count":
+		hs.SkipCount = parseInt()
+	case "Retry Count":
+		hs.RetryCount = parseInt()
+	case "Average Retry Count":
+		hs.AverageRetryCount = parseFloat()
+	case "Max Retry Count":
+		hs.MaxRetryCount = parseInt()
+	case "Max Health Check Duration":
+		hs.MaxDuration = parseDuration()
+	case "Minimum Health Check Duration":
+		hs.MinDuration = parseDuration()
+	case "Average Health Check Duration":
+		hs.AverageDuration = parseDuration()
+	case "Last Health Check":
+		hs.LastHealthCheck = parseTime()
+	case "Last Successful Health Check":
+		hs.LastSuccessfulHealthCheck = parseTime()
+	case "Last Skip Health Check":
+		hs.LastSkipHealthCheck = parseTime()
+	case "Last Failed Health Check":
+		hs.LastFailedHealthCheck = parseTime()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
+
+// HealthCheckStatsUnmarshal parses the output of pcp_health_check_stats -v,
+// reading timestamps in loc.
+func HealthCheckStatsUnmarshal(cmdOutBuff io.Reader, loc *time.Location) (HealthCheckStats, error) {
+	var hs HealthCheckStats
+	scanner := bufio.NewScanner(cmdOutBuff)
+	for scanner.Scan() {
+		key, value, ok := SplitPCPLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if err := hs.set(key, value, loc); err != nil {
+			return hs, err
+		}
+	}
+	return hs, scanner.Err()
+}
+
+// healthCheckStatsFromFields decodes the fields of a PCP health check
+// stats response, which pgpool already formats like the verbose output.
+func healthCheckStatsFromFields(fields []string, loc *time.Location) (HealthCheckStats, error) {
+	var hs HealthCheckStats
+	if len(fields) < len(healthCheckStatsKeys) {
+		return hs, parseErrorf("short PCP health check stats response: %d fields", len(fields))
+	}
+	for i, key := range healthCheckStatsKeys {
+		if err := hs.set(key, fields[i], loc); err != nil {
+			return hs, parseErrorf("health check stats: %v", err)
+		}
+	}
+	return hs, nil
+}
+
+// ExecHealthCheckStats returns the health check statistics of a node,
+// which requires FeatureHealthCheckStats.
+func (c *Client) ExecHealthCheckStats(ctx context.Context, nodeID int) (HealthCheckStats, error) {
+	if c.options.Backend == BackendSQL {
+		return c.sql.ShowPoolHealthCheckStats(ctx, nodeID)
+	}
+	if c.options.Backend == BackendNative {
+		var stats HealthCheckStats
+		err := c.pcpCommand(ctx, PCPHealthCheckStats, func(conn *pcpConn) (err error) {
+			stats, err = conn.healthCheckStats(nodeID, c.location())
+			return err
+		})
+		return stats, err
+	}
+	bytesBuffer, err := c.execCommand(ctx, PCPHealthCheckStats, fmt.Sprintf("--node-id=%d", nodeID), "-v")
+	if err != nil {
+		return HealthCheckStats{}, err
+	}
+	stats, err := HealthCheckStatsUnmarshal(bytesBuffer, c.location())
+	if err != nil {
+		return HealthCheckStats{}, parseError(PCPHealthCheckStats, err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,89 @@
+package pgpool2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckStatsUnmarshal(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2021, 2, 27, hour, min, sec, 0, loc)
+	}
+	checked := HealthCheckStats{
+		NodeID: 1, Hostname: "pg1", Port: 5432, Status: "up", Role: "standby",
+		LastStatusChange: at(15, 10, 19),
+		TotalCount:       120, SuccessCount: 118, FailCount: 2, RetryCount: 3,
+		AverageRetryCount: 0.025, MaxRetryCount: 2,
+		MaxDuration: 1003 * time.Millisecond, MinDuration: 2 * time.Millisecond,
+		AverageDuration:           10500 * time.Microsecond,
+		LastHealthCheck:           at(16, 10, 19),
+		LastSuccessfulHealthCheck: at(16, 10, 19),
+		LastFailedHealthCheck:     at(15, 40, 2),
+	}
+	// a node detached before its first health check has no timestamps
+	// besides the status change
+	neverChecked := HealthCheckStats{
+		NodeID: 1, Hostname: "pg1", Port: 5432, Status: "down", Role: "standby",
+		LastStatusChange: at(15, 10, 19),
+	}
+
+	tests := []struct {
+		version string
+		name    string
+		want    HealthCheckStats
+	}{
+		{"4.1", "pcp_health_check_stats", checked},
+		{"4.2", "pcp_health_check_stats", checked},
+		{"4.2", "pcp_health_check_stats_never_checked", neverChecked},
+		{"4.3", "pcp_health_check_stats", checked},
+		{"4.4", "pcp_health_check_stats", checked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"/"+tt.name, func(t *testing.T) {
+			got, err := HealthCheckStatsUnmarshal(openFixture(t, tt.version, tt.name), loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckStatsFromFields(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	fields := []string{
+		"0", "pg0", "5432", "up", "primary", "2021-02-27 15:10:19",
+		"10", "10", "0", "0", "0", "0.000000", "0",
+		"4", "1", "2.500000",
+		"2021-02-27 15:20:19", "2021-02-27 15:20:19", "", "",
+	}
+	want := HealthCheckStats{
+		Hostname: "pg0", Port: 5432, Status: "up", Role: "primary",
+		LastStatusChange: time.Date(2021, 2, 27, 15, 10, 19, 0, loc),
+		TotalCount:       10, SuccessCount: 10,
+		MaxDuration: 4 * time.Millisecond, MinDuration: time.Millisecond,
+		AverageDuration:           2500 * time.Microsecond,
+		LastHealthCheck:           time.Date(2021, 2, 27, 15, 20, 19, 0, loc),
+		LastSuccessfulHealthCheck: time.Date(2021, 2, 27, 15, 20, 19, 0, loc),
+	}
+	got, err := healthCheckStatsFromFields(fields, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := healthCheckStatsFromFields(fields[:len(fields)-1], loc); err == nil {
+		t.Error("short response was accepted")
+	}
+	invalid := append([]string(nil), fields...)
+	invalid[16] = "yesterday"
+	if _, err := healthCheckStatsFromFields(invalid, loc); err == nil {
+		t.Error("invalid Last Health Check was accepted")
+	}
+}
@@ -0,0 +1,67 @@
+package pgpool2
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// splitPassFileLine splits a hostname:port:username:password entry,
+// honouring backslash escapes like pcp_frontend_client does.
+func splitPassFileLine(line string) []string {
+	var fields []string
+	var field bytes.Buffer
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			field.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ':' && len(fields) < 3:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
+
+func passFileFieldMatches(pattern, value string) bool {
+	return pattern == "*" || pattern == value
+}
+
+// LookupPassFile returns the password of the first entry in the PCP
+// password file matching hostname, port and username.
+func LookupPassFile(path, hostname string, port int, username string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPassFileLine(line)
+		if len(fields) != 4 {
+			continue
+		}
+		if passFileFieldMatches(fields[0], hostname) &&
+			passFileFieldMatches(fields[1], strconv.Itoa(port)) &&
+			passFileFieldMatches(fields[2], username) {
+			return fields[3], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no entry for %s:%d:%s in %s", hostname, port, username, path)
+}
@@ -0,0 +1,47 @@
+package pgpool2
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupPassFile(t *testing.T) {
+	path := filepath.Join("testdata", "pcppass")
+	tests := []struct {
+		name     string
+		hostname string
+		port     int
+		username string
+		want     string
+	}{
+		{"exact", "localhost", 9898, "pcpadmin", "local"},
+		{"wildcard host", "pgpool1", 9898, "monitor", "any host"},
+		{"escaped host", "pg:pool", 9898, "pcpadmin", "escaped host"},
+		// the wildcard port entry comes before the exact one
+		{"first match wins", "127.0.0.1", 9898, "pcpadmin", "first:match"},
+		{"wildcard port", "127.0.0.1", 9999, "pcpadmin", "first:match"},
+		{"wildcard user", "127.0.0.1", 9898, "replicator", "any user"},
+		{"escaped backslash", "pgpool1", 9999, "monitor", `back\slash`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LookupPassFile(path, tt.hostname, tt.port, tt.username)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got password %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupPassFileNoEntry(t *testing.T) {
+	path := filepath.Join("testdata", "pcppass")
+	if password, err := LookupPassFile(path, "pgpool1", 9898, "pcpadmin"); err == nil {
+		t.Errorf("got password %q, want no entry", password)
+	}
+	if _, err := LookupPassFile(filepath.Join("testdata", "missing"), "localhost", 9898, "pcpadmin"); err == nil {
+		t.Error("missing password file was accepted")
+	}
+}
@@ -0,0 +1,558 @@
+package pgpool2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PCP packet types
+// https://github.com/pgpool/pgpool2/blob/master/src/libs/pcp/pcp.c
+const (
+	pcpSaltRequest          = 'M'
+	pcpSaltResponse         = 'm'
+	pcpAuthRequest          = 'R'
+	pcpAuthResponse         = 'r'
+	pcpNodeCountRequest     = 'L'
+	pcpNodeCountResponse    = 'l'
+	pcpNodeInfoRequest      = 'I'
+	pcpNodeInfoResponse     = 'i'
+	pcpProcCountRequest     = 'N'
+	pcpProcCountResponse    = 'n'
+	pcpProcInfoRequest      = 'P'
+	pcpProcInfoResponse     = 'p'
+	pcpWatchdogInfoRequest  = 'W'
+	pcpWatchdogInfoResponse = 'w'
+	pcpHealthCheckRequest   = 'H'
+	pcpHealthCheckResponse  = 'h'
+	pcpTerminateRequest     = 'X'
+	pcpErrorResponse        = 'E'
+	pcpNoticeResponse       = 'N'
+
+	pcpCommandComplete = "CommandComplete"
+	pcpArraySize       = "ArraySize"
+	pcpProcessInfo     = "ProcessInfo"
+	pcpNodeInfo        = "NodeInfo"
+	pcpAuthOK          = "AuthenticationOK"
+
+	pcpDialTimeout = 10 * time.Second
+	pcpKeepAlive   = 30 * time.Second
+	// backend weights are sent scaled by RAND_MAX
+	pcpRandMax = 2147483647
+)
+
+// errPCPOutOfSync is a parse error after which the remaining packets of
+// the response are unknown, so the connection cannot be reused.
+var errPCPOutOfSync = fmt.Errorf("%w: PCP stream out of sync", ErrParse)
+
+var (
+	// do not reorder
+	// https://github.com/pgpool/pgpool2/blob/master/src/include/pool_type.h
+	serverRoleToString = map[int]string{
+		0: "master",
+		1: "slave",
+		2: "primary",
+		3: "standby",
+	}
+
+	quorumCodeToState = map[int]string{
+		QuorumStateUnknown:      "UNKNOWN",
+		QuorumStateNoMasterNode: "NO MASTER NODE",
+		QuorumStateAbsent:       "QUORUM ABSENT",
+		QuorumStateOnEdge:       "QUORUM IS ON THE EDGE",
+		QuorumStateExist:        "QUORUM EXIST",
+	}
+)
+
+// pcpConn is a single connection speaking the PCP wire protocol.
+type pcpConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialPCP(ctx context.Context, network, address string) (*pcpConn, error) {
+	dialer := &net.Dialer{
+		Timeout:   pcpDialTimeout,
+		KeepAlive: pcpKeepAlive,
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &pcpConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// bind makes I/O on the connection fail once ctx is done; the returned
+// function releases the binding.
+func (p *pcpConn) bind(ctx context.Context) func() {
+	deadline, _ := ctx.Deadline()
+	p.conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			p.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+func (p *pcpConn) send(tos byte, fields ...string) error {
+	payload := &bytes.Buffer{}
+	for _, field := range fields {
+		payload.WriteString(field)
+		payload.WriteByte(0)
+	}
+	packet := make([]byte, 5, 5+payload.Len())
+	packet[0] = tos
+	binary.BigEndian.PutUint32(packet[1:5], uint32(payload.Len()+4))
+	packet = append(packet, payload.Bytes()...)
+	_, err := p.conn.Write(packet)
+	return err
+}
+
+func (p *pcpConn) receive() (byte, []byte, error) {
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(p.reader, header); err != nil {
+			return 0, nil, err
+		}
+		size := int(binary.BigEndian.Uint32(header[1:5])) - 4
+		if size < 0 {
+			return 0, nil, fmt.Errorf("%w: invalid PCP packet length %d", errPCPOutOfSync, size+4)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(p.reader, payload); err != nil {
+			return 0, nil, err
+		}
+		switch header[0] {
+		case pcpNoticeResponse:
+			continue
+		case pcpErrorResponse:
+			return 0, nil, pcpErrorFromPayload(payload)
+		}
+		return header[0], payload, nil
+	}
+}
+
+// receiveFields reads one packet of the expected type and splits its
+// NUL separated payload.
+func (p *pcpConn) receiveFields(expected byte) ([]string, error) {
+	tos, payload, err := p.receive()
+	if err != nil {
+		return nil, err
+	}
+	if tos != expected {
+		return nil, fmt.Errorf("%w: unexpected PCP response '%c', expected '%c'", errPCPOutOfSync, tos, expected)
+	}
+	return splitPCPFields(payload), nil
+}
+
+func splitPCPFields(payload []byte) []string {
+	payload = bytes.TrimSuffix(payload, []byte{0})
+	if len(payload) == 0 {
+		return []string{}
+	}
+	return strings.Split(string(payload), "\x00")
+}
+
+// pcpResponseError is an error reported by pgpool itself; the connection
+// stays usable after receiving one.
+type pcpResponseError struct {
+	Severity string
+	Message  string
+	Detail   string
+}
+
+func (e *pcpResponseError) Error() string {
+	if len(e.Detail) != 0 {
+		return fmt.Sprintf("%s: %s (%s)", e.Severity, e.Message, e.Detail)
+	}
+	return fmt.Sprintf("%s: %s", e.Severity, e.Message)
+}
+
+func pcpErrorFromPayload(payload []byte) error {
+	e := &pcpResponseError{Severity: "ERROR"}
+	for _, field := range splitPCPFields(payload) {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			e.Severity = field[1:]
+		case 'M':
+			e.Message = field[1:]
+		case 'D':
+			e.Detail = field[1:]
+		}
+	}
+	return e
+}
+
+func pcpMD5Password(password string, salt []byte) string {
+	// same scheme as pool_md5_encrypt(): md5(md5(password) + salt)
+	passwordHash := md5.Sum([]byte(password))
+	passwordHex := hex.EncodeToString(passwordHash[:])
+	saltedHash := md5.Sum(append([]byte(passwordHex), salt...))
+	return "md5" + hex.EncodeToString(saltedHash[:])
+}
+
+func (p *pcpConn) authenticate(username, password string) error {
+	if err := p.send(pcpSaltRequest); err != nil {
+		return err
+	}
+	tos, salt, err := p.receive()
+	if err != nil {
+		return err
+	}
+	if tos != pcpSaltResponse || len(salt) < 4 {
+		return parseErrorf("invalid PCP salt response")
+	}
+	if err := p.send(pcpAuthRequest, username, pcpMD5Password(password, salt[:4])); err != nil {
+		return err
+	}
+	fields, err := p.receiveFields(pcpAuthResponse)
+	if responseErr, ok := err.(*pcpResponseError); ok {
+		return fmt.Errorf("%w: %v", ErrAuthentication, responseErr)
+	}
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 || fields[0] != pcpAuthOK {
+		return ErrAuthentication
+	}
+	return nil
+}
+
+func (p *pcpConn) close() error {
+	p.send(pcpTerminateRequest)
+	return p.conn.Close()
+}
+
+func checkCommandComplete(fields []string) error {
+	if len(fields) == 0 || fields[0] != pcpCommandComplete {
+		return parseErrorf("PCP command did not complete")
+	}
+	return nil
+}
+
+func (p *pcpConn) nodeCount() (int, error) {
+	if err := p.send(pcpNodeCountRequest); err != nil {
+		return 0, err
+	}
+	fields, err := p.receiveFields(pcpNodeCountResponse)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkCommandComplete(fields); err != nil {
+		return 0, err
+	}
+	if len(fields) < 2 {
+		return 0, parseErrorf("missing node count in PCP response")
+	}
+	nodeCount, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, parseErrorf("node count: %v", err)
+	}
+	return nodeCount, nil
+}
+
+func (p *pcpConn) nodeInfo(nodeID int) (NodeInfo, error) {
+	if err := p.send(pcpNodeInfoRequest, strconv.Itoa(nodeID)); err != nil {
+		return NodeInfo{}, err
+	}
+	nodes, err := p.receiveNodeInfo()
+	if err != nil {
+		return NodeInfo{}, err
+	}
+	if len(nodes) != 1 {
+		return NodeInfo{}, parseErrorf("PCP node info response has %d records instead of 1", len(nodes))
+	}
+	return nodes[0], nil
+}
+
+func (p *pcpConn) allNodeInfo() ([]NodeInfo, error) {
+	// node id -1 requests every node
+	if err := p.send(pcpNodeInfoRequest, "-1"); err != nil {
+		return nil, err
+	}
+	return p.receiveNodeInfo()
+}
+
+// receiveNodeInfo reads the reply to a node info request up to its
+// CommandComplete record: either a single CommandComplete record carrying
+// the node, or an ArraySize record followed by one NodeInfo record per
+// node and a bare CommandComplete. All records are read even if one
+// cannot be parsed.
+func (p *pcpConn) receiveNodeInfo() ([]NodeInfo, error) {
+	var nodes []NodeInfo
+	var parseErr error
+	remaining := -1
+	for {
+		fields, err := p.receiveFields(pcpNodeInfoResponse)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%w: empty PCP node info response", errPCPOutOfSync)
+		}
+		switch fields[0] {
+		case pcpCommandComplete:
+			if len(fields) > 1 {
+				ni, err := nodeInfoFromFields(fields[1:])
+				if err != nil {
+					return nil, err
+				}
+				nodes = append(nodes, ni)
+			}
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			if remaining > 0 {
+				return nil, parseErrorf("PCP node info response is missing %d records", remaining)
+			}
+			return nodes, nil
+		case pcpArraySize:
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("%w: missing array size in PCP node info response", errPCPOutOfSync)
+			}
+			if remaining, err = strconv.Atoi(fields[1]); err != nil || remaining < 0 {
+				return nil, fmt.Errorf("%w: node info array size %q", errPCPOutOfSync, fields[1])
+			}
+		case pcpNodeInfo:
+			if remaining < 0 {
+				return nil, fmt.Errorf("%w: PCP node info record before array size", errPCPOutOfSync)
+			}
+			remaining--
+			ni, err := nodeInfoFromFields(fields[1:])
+			if err != nil {
+				if parseErr == nil {
+					parseErr = err
+				}
+				continue
+			}
+			nodes = append(nodes, ni)
+		default:
+			return nil, fmt.Errorf("%w: unexpected PCP node info record %q", errPCPOutOfSync, fields[0])
+		}
+	}
+}
+
+// nodeInfoFromFields decodes the node info record in the field order of
+// pgpool-II 4.1; shorter records sent by older releases leave the
+// trailing fields empty.
+func nodeInfoFromFields(fields []string) (NodeInfo, error) {
+	var ni NodeInfo
+	if len(fields) < 4 {
+		return ni, parseErrorf("short PCP node info response: %d fields", len(fields))
+	}
+	field := func(i int) string {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return ""
+	}
+	var err error
+	ni.Hostname = fields[0]
+	if ni.Port, err = strconv.Atoi(fields[1]); err != nil {
+		return ni, parseErrorf("node port: %v", err)
+	}
+	if ni.StatusCode, err = strconv.Atoi(fields[2]); err != nil {
+		return ni, parseErrorf("node status: %v", err)
+	}
+	ni.Status = NodeStatusCodeToString(ni.StatusCode)
+	weight, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return ni, parseErrorf("node weight: %v", err)
+	}
+	ni.Weight = weight / pcpRandMax
+	if role, err := strconv.Atoi(field(4)); err == nil {
+		ni.Role = serverRoleToString[role]
+	}
+	if delay, err := strconv.ParseFloat(field(5), 64); err == nil {
+		ni.ReplicationDelay = delay
+	}
+	ni.ReplicationState = field(6)
+	ni.ReplicationSyncState = field(7)
+	if changed, err := strconv.ParseInt(field(8), 10, 64); err == nil && changed > 0 {
+		ni.LastStatusChangeTime = time.Unix(changed, 0)
+		ni.LastStatusChange = ni.LastStatusChangeTime.Format(PCPTimeLayout)
+	}
+	return ni, nil
+}
+
+func (p *pcpConn) procCount() ([]string, error) {
+	if err := p.send(pcpProcCountRequest); err != nil {
+		return nil, err
+	}
+	fields, err := p.receiveFields(pcpProcCountResponse)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCommandComplete(fields); err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, parseErrorf("missing process count in PCP response")
+	}
+	return fields[2:], nil
+}
+
+// procInfo reads every ProcessInfo record up to CommandComplete, even if
+// one cannot be parsed, reading timestamps in loc.
+func (p *pcpConn) procInfo(loc *time.Location) ([]ProcInfo, error) {
+	var pi []ProcInfo
+	var parseErr error
+	// process id 0 requests every child process
+	if err := p.send(pcpProcInfoRequest, "0"); err != nil {
+		return nil, err
+	}
+	for {
+		fields, err := p.receiveFields(pcpProcInfoResponse)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("%w: empty PCP process info response", errPCPOutOfSync)
+		}
+		switch fields[0] {
+		case pcpArraySize:
+			continue
+		case pcpCommandComplete:
+			if parseErr != nil {
+				return nil, parseErr
+			}
+			return pi, nil
+		case pcpProcessInfo:
+			procInfo, err := procInfoFromFields(fields[1:], loc)
+			if err != nil {
+				if parseErr == nil {
+					parseErr = err
+				}
+				continue
+			}
+			pi = append(pi, procInfo)
+		default:
+			return nil, fmt.Errorf("%w: unexpected PCP process info record %q", errPCPOutOfSync, fields[0])
+		}
+	}
+}
+
+// pcpWatchdogCluster is the JSON document of the watchdog; the membership
+// counts were added in pgpool-II 4.3, 4.2 renamed Master* to Leader*.
+type pcpWatchdogCluster struct {
+	NodeCount             int    `json:"NodeCount"`
+	RemoteNodeCount       int    `json:"RemoteNodeCount"`
+	MemberRemoteNodeCount *int   `json:"MemberRemoteNodeCount"`
+	NodesRequireForQuorum *int   `json:"NodesRequireForQuorum"`
+	QuorumStatus          int    `json:"QuorumStatus"`
+	AliveNodeCount        int    `json:"AliveNodeCount"`
+	Escalated             bool   `json:"Escalated"`
+	MasterNodeName        string `json:"MasterNodeName"`
+	MasterHostName        string `json:"MasterHostName"`
+	LeaderNodeName        string `json:"LeaderNodeName"`
+	LeaderHostName        string `json:"LeaderHostName"`
+	WatchdogNodes         []struct {
+		NodeName               string `json:"NodeName"`
+		HostName               string `json:"HostName"`
+		DelegateIP             string `json:"DelegateIP"`
+		WdPort                 int    `json:"WdPort"`
+		PgpoolPort             int    `json:"PgpoolPort"`
+		State                  int    `json:"State"`
+		StateName              string `json:"StateName"`
+		Priority               int    `json:"Priority"`
+		MembershipStatusString string `json:"MembershipStatusString"`
+	} `json:"WatchdogNodes"`
+}
+
+func (p *pcpConn) watchdogInfo() (WatchdogInfo, error) {
+	var wi WatchdogInfo
+	// node id -1 requests the whole cluster
+	if err := p.send(pcpWatchdogInfoRequest, "-1"); err != nil {
+		return wi, err
+	}
+	fields, err := p.receiveFields(pcpWatchdogInfoResponse)
+	if err != nil {
+		return wi, err
+	}
+	if err := checkCommandComplete(fields); err != nil {
+		return wi, err
+	}
+	if len(fields) < 2 {
+		return wi, parseErrorf("missing watchdog info in PCP response")
+	}
+	var cluster pcpWatchdogCluster
+	if err := json.Unmarshal([]byte(fields[1]), &cluster); err != nil {
+		return wi, parseErrorf("watchdog info: %v", err)
+	}
+	wi.TotalNodes = cluster.NodeCount
+	wi.RemoteNodes = cluster.RemoteNodeCount
+	wi.AliveRemoteNodes = cluster.AliveNodeCount
+	wi.QuorumStateCode = cluster.QuorumStatus
+	wi.QuorumState = quorumCodeToState[cluster.QuorumStatus]
+	if len(wi.QuorumState) == 0 {
+		wi.QuorumStateCode = QuorumStateUnknown
+		wi.QuorumState = quorumCodeToState[QuorumStateUnknown]
+	}
+	wi.VIP = cluster.Escalated
+	wi.LeaderNodeName = cluster.LeaderNodeName
+	wi.LeaderHostName = cluster.LeaderHostName
+	if len(wi.LeaderNodeName) == 0 {
+		wi.LeaderNodeName = cluster.MasterNodeName
+		wi.LeaderHostName = cluster.MasterHostName
+	}
+	wi.LeaderNodeName = watchdogLeaderValue(wi.LeaderNodeName)
+	wi.LeaderHostName = watchdogLeaderValue(wi.LeaderHostName)
+	if cluster.MemberRemoteNodeCount != nil && cluster.NodesRequireForQuorum != nil {
+		wi.Membership = true
+		wi.MemberRemoteNodes = *cluster.MemberRemoteNodeCount
+		wi.QuorumNodesRequired = *cluster.NodesRequireForQuorum
+	}
+	for _, node := range cluster.WatchdogNodes {
+		wi.Nodes = append(wi.Nodes, WatchdogNode{
+			NodeName:         node.NodeName,
+			HostName:         node.HostName,
+			DelegateIP:       node.DelegateIP,
+			PgpoolPort:       node.PgpoolPort,
+			WatchdogPort:     node.WdPort,
+			Priority:         node.Priority,
+			Status:           node.State,
+			StatusName:       node.StateName,
+			MembershipStatus: node.MembershipStatusString,
+		})
+	}
+	return wi, nil
+}
+
+func (p *pcpConn) healthCheckStats(nodeID int, loc *time.Location) (HealthCheckStats, error) {
+	if err := p.send(pcpHealthCheckRequest, strconv.Itoa(nodeID)); err != nil {
+		return HealthCheckStats{}, err
+	}
+	fields, err := p.receiveFields(pcpHealthCheckResponse)
+	if err != nil {
+		return HealthCheckStats{}, err
+	}
+	if err := checkCommandComplete(fields); err != nil {
+		return HealthCheckStats{}, err
+	}
+	return healthCheckStatsFromFields(fields[1:], loc)
+}
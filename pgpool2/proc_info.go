@@ -0,0 +1,320 @@
+package pgpool2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of pgpool child processes, as reported by pgpool-II 4.2+ in
+// lower case with underscores.
+const (
+	ChildStatusWaitForConnection = "wait_for_connection"
+	ChildStatusIdle              = "idle"
+	ChildStatusIdleInTransaction = "idle_in_transaction"
+	ChildStatusExecuteCommand    = "execute_command"
+	// ChildStatusConnected is used for releases before 4.2 which do not
+	// tell idle from busy children
+	ChildStatusConnected = "connected"
+)
+
+// ChildStatuses lists the statuses of pgpool-II 4.2+ children
+var ChildStatuses = []string{
+	ChildStatusWaitForConnection,
+	ChildStatusIdle,
+	ChildStatusIdleInTransaction,
+	ChildStatusExecuteCommand,
+}
+
+// pcpProcessInfoKeys are the keys printed by pcp_proc_info -v, in the
+// order of the fields of the PCP ProcessInfo record
+var (
+	// pgpool-II 3.6 up to 4.1
+	pcpProcessInfoKeys41 = []string{
+		"Database",
+		"Username",
+		"Start time",
+		"Creation time",
+		"Major",
+		"Minor",
+		"Counter",
+		"Backend PID",
+		"Connected",
+		"PID",
+		"Backend ID",
+	}
+	// pgpool-II 4.2 and later
+	pcpProcessInfoKeys42 = []string{
+		"PID",
+		"Start time",
+		"Client connection count",
+		"Pool id",
+		"Backend ID",
+		"Database",
+		"Username",
+		"Backend connection time",
+		"Client connection time",
+		"Client disconnection time",
+		"Client idle duration",
+		"Major",
+		"Minor",
+		"Pool Counter",
+		"Backend PID",
+		"Connected",
+		"Status",
+		"Load balance node",
+		"client_host",
+		"client_port",
+		"statement",
+	}
+	// pcpProcessInfoMinFields42 are the fields of pcpProcessInfoKeys42 up
+	// to Status, which every release since 4.2 sends
+	pcpProcessInfoMinFields42 = 17
+)
+
+// ProcInfo describes one connection pool slot of a pgpool child process.
+// Fields which the pgpool release does not report are left zero; the
+// client fields, Status and IdleDuration are only reported from 4.2 on.
+type ProcInfo struct {
+	PID       int
+	Database  string
+	Username  string
+	Connected bool
+
+	StartTime             time.Time
+	BackendConnectionTime time.Time
+	ProtocolMajor         int
+	ProtocolMinor         int
+	PoolCounter           int
+	BackendPID            int
+	BackendID             int
+
+	ClientHost              string
+	ClientPort              int
+	ClientConnectionCount   int
+	ClientConnectionTime    time.Time
+	ClientDisconnectionTime time.Time
+	IdleDuration            time.Duration
+	Status                  string
+	LoadBalanceNode         bool
+	Statement               string
+}
+
+// parseProcTime parses a timestamp of pcp_proc_info, which is either
+// formatted, optionally followed by a remark in parentheses, or in epoch
+// seconds.
+func parseProcTime(value string, loc *time.Location) (time.Time, error) {
+	if len(value) == 0 || value == "0" {
+		return time.Time{}, nil
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	if len(value) > len(PCPTimeLayout) {
+		value = value[:len(PCPTimeLayout)]
+	}
+	return time.ParseInLocation(PCPTimeLayout, value, loc)
+}
+
+// set assigns the value printed for key, reading timestamps in loc.
+func (pi *ProcInfo) set(key, value string, loc *time.Location) error {
+	var err error
+	parseInt := func() int {
+		if len(value) == 0 {
+			return 0
+		}
+		var i int
+		i, err = strconv.Atoi(value)
+		return i
+	}
+	parseTime := func() time.Time {
+		var t time.Time
+		t, err = parseProcTime(value, loc)
+		return t
+	}
+	switch strings.ToLower(key) {
+	case "database":
+		pi.Database = value
+	case "username":
+		pi.Username = value
+	case "start time":
+		pi.StartTime = parseTime()
+	case "creation time", "backend connection time":
+		pi.BackendConnectionTime = parseTime()
+	case "major":
+		pi.ProtocolMajor = parseInt()
+	case "minor":
+		pi.ProtocolMinor = parseInt()
+	case "counter", "pool counter":
+		pi.PoolCounter = parseInt()
+	case "backend pid":
+		pi.BackendPID = parseInt()
+	case "connected":
+		pi.Connected = value == "1"
+	case "pid":
+		pi.PID = parseInt()
+	case "backend id":
+		pi.BackendID = parseInt()
+	case "client connection count":
+		pi.ClientConnectionCount = parseInt()
+	case "client connection time":
+		pi.ClientConnectionTime = parseTime()
+	case "client disconnection time":
+		pi.ClientDisconnectionTime = parseTime()
+	case "client idle duration":
+		pi.IdleDuration = time.Duration(parseInt()) * time.Second
+	case "status":
+		pi.Status = value
+	case "load balance node":
+		pi.LoadBalanceNode = value == "1"
+	case "client_host", "client host":
+		pi.ClientHost = value
+	case "client_port", "client port":
+		pi.ClientPort = parseInt()
+	case "statement":
+		pi.Statement = value
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
+
+// ProcInfoUnmarshal parses the output of pcp_proc_info --all -v, reading
+// timestamps in the local time zone.
+func ProcInfoUnmarshal(cmdOutBuff io.Reader) ([]ProcInfo, error) {
+	return ProcInfoUnmarshalInLocation(cmdOutBuff, time.Local)
+}
+
+// ProcInfoUnmarshalInLocation parses the output of pcp_proc_info --all -v,
+// reading timestamps in loc. Every pool slot starts with its Database
+// line.
+func ProcInfoUnmarshalInLocation(cmdOutBuff io.Reader, loc *time.Location) ([]ProcInfo, error) {
+	var pi []ProcInfo
+	scanner := bufio.NewScanner(cmdOutBuff)
+	for scanner.Scan() {
+		key, value, ok := SplitPCPLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		if key == "Database" {
+			pi = append(pi, ProcInfo{})
+		}
+		if len(pi) == 0 {
+			continue
+		}
+		if err := pi[len(pi)-1].set(key, value, loc); err != nil {
+			return pi, err
+		}
+	}
+	return pi, scanner.Err()
+}
+
+// procInfoFromFields decodes the fields of a PCP ProcessInfo record, whose
+// layout changed with pgpool-II 4.2. Records of 4.1 have exactly the fields
+// of pcpProcessInfoKeys41; later releases send a prefix of at least
+// pcpProcessInfoMinFields42 fields of pcpProcessInfoKeys42, the trailing
+// fields were added in later minor releases. The timestamps of 4.2+ are
+// formatted by pgpool and read in loc.
+func procInfoFromFields(fields []string, loc *time.Location) (ProcInfo, error) {
+	var pi ProcInfo
+	var keys []string
+	switch {
+	case len(fields) == len(pcpProcessInfoKeys41):
+		keys = pcpProcessInfoKeys41
+	case len(fields) >= pcpProcessInfoMinFields42:
+		keys = pcpProcessInfoKeys42
+		if len(fields) < len(keys) {
+			keys = keys[:len(fields)]
+		}
+	default:
+		return pi, parseErrorf("PCP process info record of %d fields", len(fields))
+	}
+	for i, key := range keys {
+		if err := pi.set(key, fields[i], loc); err != nil {
+			return pi, parseErrorf("process info: %v", err)
+		}
+	}
+	return pi, nil
+}
+
+// ChildStatus returns the status of a child process in the form of the
+// ChildStatus* constants.
+func ChildStatus(status string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(status)), " ", "_", -1)
+}
+
+// ChildrenSummary counts the pgpool child processes by status.
+type ChildrenSummary struct {
+	Status map[string]int
+	Total  int
+	// InUse are the children serving a client
+	InUse int
+	// HasStatus is set if pgpool reported the status of its children
+	HasStatus bool
+}
+
+// Saturation returns the share of children serving a client, clients
+// queue once it reaches 1.
+func (s ChildrenSummary) Saturation() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.InUse) / float64(s.Total)
+}
+
+// SummarizeChildren counts the child processes of the pool slots
+// returned by pcp_proc_info --all.
+func SummarizeChildren(pi []ProcInfo) ChildrenSummary {
+	summary := ChildrenSummary{
+		Status: make(map[string]int),
+	}
+	// every child reports one slot per pool and backend
+	statuses := make(map[int]string)
+	var pids []int
+	for _, procInfo := range pi {
+		status, seen := statuses[procInfo.PID]
+		if !seen {
+			pids = append(pids, procInfo.PID)
+		}
+		switch {
+		case len(procInfo.Status) != 0:
+			status = ChildStatus(procInfo.Status)
+			summary.HasStatus = true
+		case len(status) == 0 && procInfo.Connected:
+			status = ChildStatusConnected
+		}
+		statuses[procInfo.PID] = status
+	}
+	for _, pid := range pids {
+		status := statuses[pid]
+		if len(status) == 0 {
+			status = ChildStatusWaitForConnection
+		}
+		summary.Status[status]++
+		summary.Total++
+		if status != ChildStatusWaitForConnection {
+			summary.InUse++
+		}
+	}
+	return summary
+}
+
+// FrontendConnections returns one pool slot per child process serving a
+// client, which identifies the database, user and client of the
+// connection.
+func FrontendConnections(pi []ProcInfo) []ProcInfo {
+	var connections []ProcInfo
+	seen := make(map[int]bool)
+	for _, procInfo := range pi {
+		if !procInfo.Connected || seen[procInfo.PID] {
+			continue
+		}
+		seen[procInfo.PID] = true
+		connections = append(connections, procInfo)
+	}
+	return connections
+}
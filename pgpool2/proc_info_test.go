@@ -0,0 +1,242 @@
+package pgpool2
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProcInfoUnmarshalInLocation(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2021, 2, 27, hour, min, sec, 0, loc)
+	}
+	// pgpool-II 4.1 prints neither the client nor the status
+	legacy := []ProcInfo{
+		{
+			PID: 4321, Database: "app", Username: "web", Connected: true,
+			StartTime: at(15, 10, 19), BackendConnectionTime: at(15, 11, 0),
+			ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345,
+		},
+		{PID: 4322, StartTime: at(15, 10, 19)},
+	}
+	status := []ProcInfo{
+		{
+			PID: 4321, Database: "app", Username: "web", Connected: true,
+			StartTime: at(15, 10, 19), BackendConnectionTime: at(15, 11, 0),
+			ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345,
+			ClientConnectionCount: 3, ClientConnectionTime: at(15, 12, 0),
+			IdleDuration: 30 * time.Second, Status: "Idle",
+		},
+		{PID: 4322, StartTime: at(15, 10, 19), Status: "Wait for connection"},
+	}
+	client := make([]ProcInfo, len(status))
+	copy(client, status)
+	client[0].LoadBalanceNode = true
+	client[0].ClientHost = "10.0.0.5"
+	client[0].ClientPort = 51234
+
+	tests := []struct {
+		version string
+		want    []ProcInfo
+	}{
+		{"4.1", legacy},
+		// the start time is followed by the time until the restart of
+		// the child
+		{"4.2", status},
+		{"4.3", client},
+		{"4.4", client},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ProcInfoUnmarshalInLocation(openFixture(t, tt.version, "pcp_proc_info"), loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcInfoFromFields(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	local := func(value string) time.Time {
+		tm, err := time.ParseInLocation(PCPTimeLayout, value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		name    string
+		fields  []string
+		want    ProcInfo
+		wantErr bool
+	}{
+		{
+			name: "4.1",
+			fields: []string{
+				"app", "web", "1614438619", "1614438660",
+				"3", "0", "1", "12345", "1", "4321", "0",
+			},
+			want: ProcInfo{
+				PID: 4321, Database: "app", Username: "web", Connected: true,
+				StartTime: time.Unix(1614438619, 0), BackendConnectionTime: time.Unix(1614438660, 0),
+				ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345,
+			},
+		},
+		{
+			name: "4.2",
+			fields: []string{
+				"4321", "2021-02-27 15:10:19 (2:55 before process restarting)", "3", "0", "0",
+				"app", "web", "2021-02-27 15:11:00", "2021-02-27 15:12:00", "", "30",
+				"3", "0", "1", "12345", "1", "Idle", "1", "10.0.0.5", "51234", "",
+			},
+			want: ProcInfo{
+				PID: 4321, Database: "app", Username: "web", Connected: true,
+				StartTime: local("2021-02-27 15:10:19"), BackendConnectionTime: local("2021-02-27 15:11:00"),
+				ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345,
+				ClientConnectionCount: 3, ClientConnectionTime: local("2021-02-27 15:12:00"),
+				IdleDuration: 30 * time.Second, Status: "Idle", LoadBalanceNode: true,
+				ClientHost: "10.0.0.5", ClientPort: 51234,
+			},
+		},
+		{
+			// 4.2.0 sends the fields up to Status only
+			name: "4.2 without client",
+			fields: []string{
+				"4322", "2021-02-27 15:10:19 (2:55 before process restarting)", "0", "0", "0",
+				"", "", "", "", "", "0",
+				"0", "0", "0", "0", "0", "Wait for connection",
+			},
+			want: ProcInfo{
+				PID: 4322, StartTime: local("2021-02-27 15:10:19"), Status: "Wait for connection",
+			},
+		},
+		{
+			name: "4.2 with load balance node",
+			fields: []string{
+				"4321", "2021-02-27 15:10:19", "3", "0", "1",
+				"app", "web", "2021-02-27 15:11:00", "2021-02-27 15:12:00", "", "30",
+				"3", "0", "1", "12345", "1", "Idle", "1",
+			},
+			want: ProcInfo{
+				PID: 4321, Database: "app", Username: "web", Connected: true,
+				StartTime: local("2021-02-27 15:10:19"), BackendConnectionTime: local("2021-02-27 15:11:00"),
+				ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345, BackendID: 1,
+				ClientConnectionCount: 3, ClientConnectionTime: local("2021-02-27 15:12:00"),
+				IdleDuration: 30 * time.Second, Status: "Idle", LoadBalanceNode: true,
+			},
+		},
+		{
+			name:    "between the layouts",
+			fields:  []string{"4321", "2021-02-27 15:10:19", "3", "0", "1", "app", "web", "", "", "", "30", "3"},
+			wantErr: true,
+		},
+		{
+			name:    "short",
+			fields:  []string{"app", "web", "1614438619"},
+			wantErr: true,
+		},
+		{
+			name: "invalid pid",
+			fields: []string{
+				"app", "web", "1614438619", "1614438660",
+				"3", "0", "1", "12345", "1", "pid", "0",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := procInfoFromFields(tt.fields, loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeProcInfo(t *testing.T) {
+	pi, err := ProcInfoUnmarshal(openFixture(t, "4.4", "pcp_proc_info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi = append(pi, ProcInfo{PID: 4323, Database: "app", Username: "web"})
+	summary := SummarizeProcInfo(pi)
+	want := ProcInfoSummary{
+		Active:   map[string]int{"app": 1},
+		Inactive: map[string]int{"app": 1},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("got %+v, want %+v without the unused slot", summary, want)
+	}
+}
+
+// TestNativeProcInfo reads the 17 field records of pgpool-II 4.2.0 from the
+// native backend, in the time zone of the client.
+func TestNativeProcInfo(t *testing.T) {
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		writePCPFields(conn, pcpProcInfoResponse, pcpArraySize, "2")
+		writePCPFields(conn, pcpProcInfoResponse, pcpProcessInfo,
+			"4321", "2021-02-27 15:10:19 (2:55 before process restarting)", "3", "0", "0",
+			"app", "web", "2021-02-27 15:11:00", "2021-02-27 15:12:00", "", "30",
+			"3", "0", "1", "12345", "1", "Idle")
+		writePCPFields(conn, pcpProcInfoResponse, pcpProcessInfo,
+			"4322", "2021-02-27 15:10:19 (2:55 before process restarting)", "0", "0", "0",
+			"", "", "", "", "", "0",
+			"0", "0", "0", "0", "0", "Wait for connection")
+		writePCPFields(conn, pcpProcInfoResponse, pcpCommandComplete)
+	})
+	host, port, err := net.SplitHostPort(server.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNumber, _ := strconv.Atoi(port)
+	loc := time.FixedZone("CEST", 2*60*60)
+	client, err := NewClient(Options{
+		Backend:  BackendNative,
+		Hostname: host,
+		Port:     portNumber,
+		Username: "pcpadmin",
+		Password: "secret",
+		Version:  "4.2.0",
+		Location: loc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Clean()
+	got, err := client.ExecProcInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2021, 2, 27, 15, 10, 19, 0, loc)
+	want := []ProcInfo{
+		{
+			PID: 4321, Database: "app", Username: "web", Connected: true,
+			StartTime: started, BackendConnectionTime: time.Date(2021, 2, 27, 15, 11, 0, 0, loc),
+			ProtocolMajor: 3, PoolCounter: 1, BackendPID: 12345,
+			ClientConnectionCount: 3, ClientConnectionTime: time.Date(2021, 2, 27, 15, 12, 0, 0, loc),
+			IdleDuration: 30 * time.Second, Status: "Idle",
+		},
+		{PID: 4322, StartTime: started, Status: "Wait for connection"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,173 @@
+package pgpool2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionStats describes the persistent PCP session of the native backend.
+type SessionStats struct {
+	Connected       bool
+	Established     time.Time
+	Reconnects      uint64
+	AuthFailures    uint64
+	LastAuthFailure time.Time
+}
+
+// pcpSession holds one authenticated PCP connection which is shared by all
+// commands and re-established whenever pgpool drops it.
+type pcpSession struct {
+	// busy serializes commands, unlike a mutex waiting for it honours
+	// the deadline of the command
+	busy     chan struct{}
+	network  string
+	address  string
+	username string
+	password string
+
+	// mu guards the fields below which are also read by stats()
+	mu              sync.Mutex
+	conn            *pcpConn
+	established     time.Time
+	connects        uint64
+	authFailures    uint64
+	lastAuthFailure time.Time
+}
+
+func newPCPSession(network, address, username, password string) *pcpSession {
+	return &pcpSession{
+		busy:     make(chan struct{}, 1),
+		network:  network,
+		address:  address,
+		username: username,
+		password: password,
+	}
+}
+
+func isPCPAuthError(err error) bool {
+	return errors.Is(err, ErrAuthentication)
+}
+
+// isPCPSessionReset reports whether err means the session has to be
+// re-established: the connection broke, or pgpool forgot our
+// authentication (e.g. after a restart of the PCP process). Responses
+// which cannot be parsed are never retried; run drops the connection if
+// they left the stream out of sync.
+func isPCPSessionReset(err error) bool {
+	var responseErr *pcpResponseError
+	if errors.As(err, &responseErr) {
+		return strings.Contains(strings.ToLower(responseErr.Message), "authenticat")
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (s *pcpSession) connect(ctx context.Context) (*pcpConn, error) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	conn, err := dialPCP(ctx, s.network, s.address)
+	if err != nil {
+		return nil, err
+	}
+	release := conn.bind(ctx)
+	err = conn.authenticate(s.username, s.password)
+	release()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		conn.close()
+		if isPCPAuthError(err) {
+			s.authFailures++
+			s.lastAuthFailure = time.Now()
+		}
+		return nil, err
+	}
+	s.conn = conn
+	s.established = time.Now()
+	s.connects++
+	return conn, nil
+}
+
+func (s *pcpSession) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return
+	}
+	s.conn.close()
+	s.conn = nil
+}
+
+func (s *pcpSession) run(ctx context.Context, conn *pcpConn, fn func(conn *pcpConn) error) error {
+	release := conn.bind(ctx)
+	defer release()
+	err := fn(conn)
+	if err != nil && (ctx.Err() != nil || isPCPSessionReset(err) || errors.Is(err, errPCPOutOfSync)) {
+		// an interrupted exchange leaves the protocol state unknown
+		s.drop()
+	}
+	return err
+}
+
+// do runs fn on the session, reconnecting and retrying once if the
+// existing connection turned out to be stale. Parse errors are never
+// retried, pgpool would send the same response again.
+func (s *pcpSession) do(ctx context.Context, fn func(conn *pcpConn) error) error {
+	select {
+	case s.busy <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() {
+		<-s.busy
+	}()
+	s.mu.Lock()
+	reused := s.conn != nil
+	s.mu.Unlock()
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	err = s.run(ctx, conn, fn)
+	if err == nil || !reused || ctx.Err() != nil || !isPCPSessionReset(err) {
+		return err
+	}
+	conn, err = s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, conn, fn)
+}
+
+func (s *pcpSession) stats() SessionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := SessionStats{
+		Connected:       s.conn != nil,
+		AuthFailures:    s.authFailures,
+		LastAuthFailure: s.lastAuthFailure,
+	}
+	if s.conn != nil {
+		stats.Established = s.established
+	}
+	if s.connects > 1 {
+		stats.Reconnects = s.connects - 1
+	}
+	return stats
+}
+
+func (s *pcpSession) close() {
+	s.drop()
+}
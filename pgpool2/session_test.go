@@ -0,0 +1,245 @@
+package pgpool2
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakePCPServer is a PCP listener accepting any password. respond is
+// called for every request after authentication and writes the reply.
+type fakePCPServer struct {
+	listener net.Listener
+	respond  func(conn net.Conn, tos byte, fields []string)
+	conns    int32
+	wg       sync.WaitGroup
+}
+
+func newFakePCPServer(t *testing.T, respond func(conn net.Conn, tos byte, fields []string)) *fakePCPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakePCPServer{listener: listener, respond: respond}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *fakePCPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.conns, 1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *fakePCPServer) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return
+		}
+		payload := make([]byte, int(binary.BigEndian.Uint32(header[1:5]))-4)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			return
+		}
+		fields := splitPCPFields(payload)
+		switch header[0] {
+		case pcpSaltRequest:
+			writePCPPacket(conn, pcpSaltResponse, "salt")
+		case pcpAuthRequest:
+			writePCPPacket(conn, pcpAuthResponse, pcpAuthOK+"\x00")
+		case pcpTerminateRequest:
+			return
+		default:
+			s.respond(conn, header[0], fields)
+		}
+	}
+}
+
+func (s *fakePCPServer) connections() int {
+	return int(atomic.LoadInt32(&s.conns))
+}
+
+func writePCPPacket(w io.Writer, tos byte, payload string) {
+	packet := make([]byte, 5, 5+len(payload))
+	packet[0] = tos
+	binary.BigEndian.PutUint32(packet[1:5], uint32(len(payload)+4))
+	w.Write(append(packet, payload...))
+}
+
+// writePCPFields writes a packet of NUL terminated fields.
+func writePCPFields(w io.Writer, tos byte, fields ...string) {
+	writePCPPacket(w, tos, strings.Join(fields, "\x00")+"\x00")
+}
+
+func nodeCountCommand(conn *pcpConn) error {
+	_, err := conn.nodeCount()
+	return err
+}
+
+func TestSessionKeepsConnectionOnParseError(t *testing.T) {
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		writePCPFields(conn, pcpNodeCountResponse, pcpCommandComplete, "notanumber")
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	for i := 0; i < 3; i++ {
+		err := session.do(context.Background(), nodeCountCommand)
+		if !errors.Is(err, ErrParse) {
+			t.Fatalf("command %d: got error %v, want ErrParse", i, err)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("got %d connections for 3 commands, want 1", got)
+	}
+	stats := session.stats()
+	if !stats.Connected || stats.Reconnects != 0 {
+		t.Errorf("got %+v, want a connected session without reconnects", stats)
+	}
+}
+
+func TestSessionReconnectsOnEOF(t *testing.T) {
+	var requests int32
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		if atomic.AddInt32(&requests, 1) == 2 {
+			// pgpool restarted between the first and second command
+			conn.Close()
+			return
+		}
+		writePCPFields(conn, pcpNodeCountResponse, pcpCommandComplete, "2")
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	for i := 0; i < 2; i++ {
+		if err := session.do(context.Background(), nodeCountCommand); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+	if got := server.connections(); got != 2 {
+		t.Errorf("got %d connections, want 2", got)
+	}
+	if stats := session.stats(); stats.Reconnects != 1 {
+		t.Errorf("got %d reconnects, want 1", stats.Reconnects)
+	}
+}
+
+func TestSessionKeepsConnectionOnErrorResponse(t *testing.T) {
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		writePCPFields(conn, pcpErrorResponse, "SERROR", "Minvalid node id", "Dnode id 7 does not exist")
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	for i := 0; i < 2; i++ {
+		err := session.do(context.Background(), func(conn *pcpConn) error {
+			_, err := conn.nodeInfo(7)
+			return err
+		})
+		if err == nil {
+			t.Fatalf("command %d: got no error", i)
+		}
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+}
+
+func TestSessionDropsConnectionOutOfSync(t *testing.T) {
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		// a reply to another command
+		writePCPFields(conn, pcpProcCountResponse, pcpCommandComplete, "0")
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	err := session.do(context.Background(), nodeCountCommand)
+	if !errors.Is(err, ErrParse) {
+		t.Fatalf("got error %v, want ErrParse", err)
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("got %d connections, want 1 as parse errors are not retried", got)
+	}
+	if session.stats().Connected {
+		t.Error("session kept a connection in unknown protocol state")
+	}
+}
+
+func TestSessionAllNodeInfoReadsCommandComplete(t *testing.T) {
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		switch tos {
+		case pcpNodeInfoRequest:
+			writePCPFields(conn, pcpNodeInfoResponse, pcpArraySize, "2")
+			writePCPFields(conn, pcpNodeInfoResponse, pcpNodeInfo, "pg0", "5432", "2", "1073741823", "0", "0", "", "", "1614438619")
+			writePCPFields(conn, pcpNodeInfoResponse, pcpNodeInfo, "pg1", "5432", "2", "1073741823", "1", "1024", "streaming", "async", "1614438619")
+			writePCPFields(conn, pcpNodeInfoResponse, pcpCommandComplete)
+		default:
+			writePCPFields(conn, pcpNodeCountResponse, pcpCommandComplete, "2")
+		}
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	var nodes []NodeInfo
+	err := session.do(context.Background(), func(conn *pcpConn) (err error) {
+		nodes, err = conn.allNodeInfo()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[1].Hostname != "pg1" || nodes[1].ReplicationState != "streaming" {
+		t.Errorf("got nodes %+v", nodes)
+	}
+	// the next command must not receive the CommandComplete of the last one
+	if err := session.do(context.Background(), nodeCountCommand); err != nil {
+		t.Fatalf("command after node info: %v", err)
+	}
+	if got := server.connections(); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+}
+
+func TestSessionDoesNotRetryOutOfSyncOnReusedConnection(t *testing.T) {
+	var requests int32
+	server := newFakePCPServer(t, func(conn net.Conn, tos byte, fields []string) {
+		if atomic.AddInt32(&requests, 1) == 2 {
+			writePCPFields(conn, pcpProcCountResponse, pcpCommandComplete, "0")
+			return
+		}
+		writePCPFields(conn, pcpNodeCountResponse, pcpCommandComplete, "2")
+	})
+	session := newPCPSession("tcp", server.listener.Addr().String(), "pcpadmin", "secret")
+	defer session.close()
+	if err := session.do(context.Background(), nodeCountCommand); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.do(context.Background(), nodeCountCommand); !errors.Is(err, ErrParse) {
+		t.Fatalf("got error %v, want ErrParse", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("got %d requests, want 2 as parse errors are not retried", got)
+	}
+	if session.stats().Connected {
+		t.Error("session kept a connection in unknown protocol state")
+	}
+}
@@ -0,0 +1,44 @@
+package pgpool2
+
+import "context"
+
+// Source provides the pgpool state collected by the exporter. *Client is
+// the PCP implementation.
+type Source interface {
+	// Ping checks that pgpool accepts our connection and credentials.
+	Ping(ctx context.Context) error
+	ExecNodeCount(ctx context.Context) (int, error)
+	ExecNodeInfo(ctx context.Context, nodeID int) (NodeInfo, error)
+	// ExecAllNodeInfo returns every node indexed by node id, it fails
+	// unless the release has FeatureAllNodeInfo.
+	ExecAllNodeInfo(ctx context.Context) ([]NodeInfo, error)
+	ExecProcCount(ctx context.Context) ([]string, error)
+	ExecProcInfo(ctx context.Context) ([]ProcInfo, error)
+	ExecWatchdogInfo(ctx context.Context) (WatchdogInfo, error)
+	ExecHealthCheckStats(ctx context.Context, nodeID int) (HealthCheckStats, error)
+	// ExecPoolNodes returns the nodes with their load balancing counters,
+	// which are only available over SQL.
+	ExecPoolNodes(ctx context.Context) ([]PoolNode, error)
+	// ExecPoolCache returns the query cache state, which is only
+	// available over SQL.
+	ExecPoolCache(ctx context.Context) (PoolCache, error)
+	// ExecBackendStats returns the statements and errors of every node,
+	// which are only available over SQL.
+	ExecBackendStats(ctx context.Context) ([]BackendStats, error)
+	// ServerVersion returns the pgpool release, or the zero Version and
+	// an error if it is unknown.
+	ServerVersion(ctx context.Context) (Version, error)
+	// Peer returns a Source for another pgpool of the same cluster.
+	Peer(address string) (Source, error)
+}
+
+// SessionReporter is implemented by sources keeping a persistent PCP
+// session.
+type SessionReporter interface {
+	SessionStats() (SessionStats, bool)
+}
+
+var (
+	_ Source          = (*Client)(nil)
+	_ SessionReporter = (*Client)(nil)
+)
@@ -0,0 +1,513 @@
+package pgpool2
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// SHOW commands of pgpool, run on its frontend port
+// https://www.pgpool.net/docs/latest/en/html/sql-commands.html
+const (
+	SQLShowPoolNodes            = "SHOW POOL_NODES"
+	SQLShowPoolProcesses        = "SHOW POOL_PROCESSES"
+	SQLShowPoolPools            = "SHOW POOL_POOLS"
+	SQLShowPoolVersion          = "SHOW POOL_VERSION"
+	SQLShowPoolCache            = "SHOW POOL_CACHE"
+	SQLShowPoolBackendStats     = "SHOW POOL_BACKEND_STATS"
+	SQLShowPoolHealthCheckStats = "SHOW POOL_HEALTH_CHECK_STATS"
+)
+
+var (
+	// poolPoolsColumnToKey maps the columns of SHOW POOL_POOLS to the keys
+	// of pcp_proc_info -v
+	poolPoolsColumnToKey = map[string]string{
+		"pool_pid":                  "PID",
+		"start_time":                "Start time",
+		"backend_id":                "Backend ID",
+		"database":                  "Database",
+		"username":                  "Username",
+		"create_time":               "Creation time",
+		"backend_connection_time":   "Backend connection time",
+		"majorversion":              "Major",
+		"minorversion":              "Minor",
+		"pool_counter":              "Pool Counter",
+		"pool_backendpid":           "Backend PID",
+		"pool_connected":            "Connected",
+		"client_connection_count":   "Client connection count",
+		"client_connection_time":    "Client connection time",
+		"client_disconnection_time": "Client disconnection time",
+		"client_idle_duration":      "Client idle duration",
+		"status":                    "Status",
+		"load_balance_node":         "Load balance node",
+		"client_host":               "client_host",
+		"client_port":               "client_port",
+		"statement":                 "statement",
+	}
+
+	// healthCheckColumnToKey maps the columns of SHOW
+	// POOL_HEALTH_CHECK_STATS to the keys of pcp_health_check_stats -v
+	healthCheckColumnToKey = map[string]string{
+		"node_id":                      "Node Id",
+		"hostname":                     "Host Name",
+		"port":                         "Port",
+		"status":                       "Status",
+		"role":                         "Role",
+		"last_status_change":           "Last Status Change",
+		"total_count":                  "Total Count",
+		"success_count":                "Success Count",
+		"fail_count":                   "Fail Count",
+		"skip_count":                   "Skip Count",
+		"retry_count":                  "Retry Count",
+		"average_retry_count":          "Average Retry Count",
+		"max_retry_count":              "Max Retry Count",
+		"max_duration":                 "Max Health Check Duration",
+		"min_duration":                 "Minimum Health Check Duration",
+		"average_duration":             "Average Health Check Duration",
+		"last_health_check":            "Last Health Check",
+		"last_successful_health_check": "Last Successful Health Check",
+		"last_skip_health_check":       "Last Skip Health Check",
+		"last_failed_health_check":     "Last Failed Health Check",
+	}
+)
+
+// PoolNode is a row of SHOW POOL_NODES.
+type PoolNode struct {
+	NodeID int
+	NodeInfo
+	// SelectCount is the number of SELECTs load balanced to the node
+	// since pgpool started
+	SelectCount     int64
+	LoadBalanceNode bool
+}
+
+// PoolCache is the query cache state from SHOW POOL_CACHE.
+type PoolCache struct {
+	CacheHits                int64
+	Selects                  int64
+	CacheHitRatio            float64
+	HashEntries              int64
+	UsedHashEntries          int64
+	CacheEntries             int64
+	UsedCacheEntriesSize     int64
+	FreeCacheEntriesSize     int64
+	FragmentCacheEntriesSize int64
+}
+
+// BackendStats are the statements a node received, from SHOW
+// POOL_BACKEND_STATS.
+type BackendStats struct {
+	NodeID      int
+	Hostname    string
+	Port        int
+	SelectCount int64
+	InsertCount int64
+	UpdateCount int64
+	DeleteCount int64
+	DDLCount    int64
+	OtherCount  int64
+	// ErrorCounts is set if pgpool reported the counts below
+	ErrorCounts bool
+	PanicCount  int64
+	FatalCount  int64
+	ErrorCount  int64
+}
+
+// SQLClient runs pgpool's SHOW commands on its frontend port, which works
+// without PCP access. Timestamps are read in loc and every command is
+// bounded by timeout unless it is 0.
+type SQLClient struct {
+	db      *sql.DB
+	timeout time.Duration
+	loc     *time.Location
+}
+
+// NewSQLClient connects lazily to the pgpool at dsn, a lib/pq connection
+// string like "postgres://monitor@localhost:9999/postgres".
+func NewSQLClient(dsn string, timeout time.Duration, loc *time.Location) (*SQLClient, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pgpool DSN: %v", err)
+	}
+	// every connection occupies a pgpool child
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	if loc == nil {
+		loc = time.Local
+	}
+	return &SQLClient{db: db, timeout: timeout, loc: loc}, nil
+}
+
+func classifySQLError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "28":
+			// invalid_authorization_specification
+			return ErrAuthentication
+		case "42":
+			// unknown SHOW command, older pgpool
+			return ErrUnsupported
+		}
+		return classifyMessage(pqErr.Message)
+	}
+	if reason := classifyNativeError(err); reason != nil {
+		return reason
+	}
+	return classifyMessage(err.Error())
+}
+
+// query runs a SHOW command and returns its rows keyed by column name.
+func (s *SQLClient) query(ctx context.Context, stmt string) ([]map[string]string, error) {
+	var cancel context.CancelFunc
+	if s.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, commandError(ctx, stmt, err, classifySQLError(err), "")
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, commandError(ctx, stmt, err, classifySQLError(err), "")
+	}
+	var result []map[string]string
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, parseError(stmt, err)
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = strings.TrimSpace(values[i].String)
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, commandError(ctx, stmt, err, classifySQLError(err), "")
+	}
+	return result, nil
+}
+
+// leadingNumber returns the number at the start of value, replication
+// delays may be followed by a unit.
+func leadingNumber(value string) string {
+	if fields := strings.Fields(value); len(fields) != 0 {
+		return fields[0]
+	}
+	return ""
+}
+
+// poolNodeFromRow maps a row of SHOW POOL_NODES, whose status is a name
+// instead of the code printed by pcp_node_info.
+func (s *SQLClient) poolNodeFromRow(row map[string]string) (PoolNode, error) {
+	var pn PoolNode
+	var err error
+	if pn.NodeID, err = strconv.Atoi(row["node_id"]); err != nil {
+		return pn, fmt.Errorf("node_id: %v", err)
+	}
+	pn.Hostname = row["hostname"]
+	if pn.Port, err = strconv.Atoi(row["port"]); err != nil {
+		return pn, fmt.Errorf("port: %v", err)
+	}
+	pn.StatusCode = -1
+	for code, state := range NodeStates {
+		if row["status"] == state || row["status"] == strconv.Itoa(code) {
+			pn.StatusCode = code
+		}
+	}
+	pn.Status = NodeStatusCodeToString(pn.StatusCode)
+	if pn.Weight, err = strconv.ParseFloat(row["lb_weight"], 64); err != nil {
+		return pn, fmt.Errorf("lb_weight: %v", err)
+	}
+	pn.Role = row["role"]
+	pn.ReplicationDelay, _ = strconv.ParseFloat(leadingNumber(row["replication_delay"]), 64)
+	pn.ReplicationState = row["replication_state"]
+	pn.ReplicationSyncState = row["replication_sync_state"]
+	if changed := row["last_status_change"]; len(changed) != 0 {
+		pn.LastStatusChange = changed
+		if t, err := time.ParseInLocation(PCPTimeLayout, changed, s.loc); err == nil {
+			pn.LastStatusChangeTime = t
+		}
+	}
+	if pn.SelectCount, err = strconv.ParseInt(row["select_cnt"], 10, 64); err != nil {
+		return pn, fmt.Errorf("select_cnt: %v", err)
+	}
+	pn.LoadBalanceNode = row["load_balance_node"] == "true"
+	return pn, nil
+}
+
+// ShowPoolNodes returns the backend nodes, indexed by node id.
+func (s *SQLClient) ShowPoolNodes(ctx context.Context) ([]PoolNode, error) {
+	rows, err := s.query(ctx, SQLShowPoolNodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]PoolNode, 0, len(rows))
+	for _, row := range rows {
+		pn, err := s.poolNodeFromRow(row)
+		if err != nil {
+			return nil, parseError(SQLShowPoolNodes, err)
+		}
+		nodes = append(nodes, pn)
+	}
+	return nodes, nil
+}
+
+// procInfoFromRow maps a row of SHOW POOL_POOLS onto the keys of
+// pcp_proc_info -v.
+func (s *SQLClient) procInfoFromRow(row map[string]string) (ProcInfo, error) {
+	var procInfo ProcInfo
+	for column, value := range row {
+		key, ok := poolPoolsColumnToKey[column]
+		if !ok {
+			continue
+		}
+		if err := procInfo.set(key, value, s.loc); err != nil {
+			return procInfo, err
+		}
+	}
+	return procInfo, nil
+}
+
+// ShowPoolPools returns one ProcInfo per connection pool slot of every
+// child, like pcp_proc_info --all.
+func (s *SQLClient) ShowPoolPools(ctx context.Context) ([]ProcInfo, error) {
+	rows, err := s.query(ctx, SQLShowPoolPools)
+	if err != nil {
+		return nil, err
+	}
+	pi := make([]ProcInfo, 0, len(rows))
+	for _, row := range rows {
+		procInfo, err := s.procInfoFromRow(row)
+		if err != nil {
+			return nil, parseError(SQLShowPoolPools, err)
+		}
+		pi = append(pi, procInfo)
+	}
+	return pi, nil
+}
+
+// ShowPoolProcesses returns the PIDs of the pgpool children.
+func (s *SQLClient) ShowPoolProcesses(ctx context.Context) ([]string, error) {
+	rows, err := s.query(ctx, SQLShowPoolProcesses)
+	if err != nil {
+		return nil, err
+	}
+	// SHOW POOL_PROCESSES lists a child once per pool
+	var pids []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		pid := row["pool_pid"]
+		if len(pid) == 0 || seen[pid] {
+			continue
+		}
+		seen[pid] = true
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// ShowPoolHealthCheckStats returns the health check statistics of a node,
+// which requires FeatureHealthCheckStats.
+func (s *SQLClient) ShowPoolHealthCheckStats(ctx context.Context, nodeID int) (HealthCheckStats, error) {
+	rows, err := s.query(ctx, SQLShowPoolHealthCheckStats)
+	if err != nil {
+		return HealthCheckStats{}, err
+	}
+	for _, row := range rows {
+		if row["node_id"] != strconv.Itoa(nodeID) {
+			continue
+		}
+		var hs HealthCheckStats
+		for column, value := range row {
+			key, ok := healthCheckColumnToKey[column]
+			if !ok {
+				continue
+			}
+			if err := hs.set(key, value, s.loc); err != nil {
+				return HealthCheckStats{}, parseError(SQLShowPoolHealthCheckStats, err)
+			}
+		}
+		return hs, nil
+	}
+	return HealthCheckStats{}, &CommandError{
+		Command: CommandName(SQLShowPoolHealthCheckStats),
+		Reason:  ErrUnknownNode,
+		Err:     fmt.Errorf("node id %d does not exist", nodeID),
+	}
+}
+
+// ShowPoolVersion returns the release of the pgpool we are connected to.
+func (s *SQLClient) ShowPoolVersion(ctx context.Context) (Version, error) {
+	rows, err := s.query(ctx, SQLShowPoolVersion)
+	if err != nil {
+		return Version{}, err
+	}
+	if len(rows) == 0 {
+		return Version{}, parseError(SQLShowPoolVersion, errors.New("no rows"))
+	}
+	v, err := ParseVersion(rows[0]["pool_version"])
+	if err != nil {
+		return Version{}, parseError(SQLShowPoolVersion, err)
+	}
+	return v, nil
+}
+
+// poolCacheFromRow maps the row of SHOW POOL_CACHE.
+func poolCacheFromRow(row map[string]string) (PoolCache, error) {
+	var pc PoolCache
+	var err error
+	fields := []struct {
+		column string
+		dest   *int64
+	}{
+		{"num_cache_hits", &pc.CacheHits},
+		{"num_selects", &pc.Selects},
+		{"num_hash_entries", &pc.HashEntries},
+		{"used_hash_entries", &pc.UsedHashEntries},
+		{"num_cache_entries", &pc.CacheEntries},
+		{"used_cache_entries_size", &pc.UsedCacheEntriesSize},
+		{"free_cache_entries_size", &pc.FreeCacheEntriesSize},
+		{"fragment_cache_entries_size", &pc.FragmentCacheEntriesSize},
+	}
+	for _, field := range fields {
+		// the counters are empty while the query cache is disabled
+		if value := row[field.column]; len(value) != 0 {
+			if *field.dest, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return pc, fmt.Errorf("%s: %v", field.column, err)
+			}
+		}
+	}
+	pc.CacheHitRatio, _ = strconv.ParseFloat(row["cache_hit_ratio"], 64)
+	return pc, nil
+}
+
+// ShowPoolCache returns the state of the query cache.
+func (s *SQLClient) ShowPoolCache(ctx context.Context) (PoolCache, error) {
+	rows, err := s.query(ctx, SQLShowPoolCache)
+	if err != nil {
+		return PoolCache{}, err
+	}
+	if len(rows) == 0 {
+		return PoolCache{}, parseError(SQLShowPoolCache, errors.New("no rows"))
+	}
+	pc, err := poolCacheFromRow(rows[0])
+	if err != nil {
+		return PoolCache{}, parseError(SQLShowPoolCache, err)
+	}
+	return pc, nil
+}
+
+// backendStatsFromRow maps a row of SHOW POOL_BACKEND_STATS, the error
+// counts are only reported by newer releases.
+func backendStatsFromRow(row map[string]string) (BackendStats, error) {
+	var bs BackendStats
+	var err error
+	bs.Hostname = row["hostname"]
+	ints := []struct {
+		column string
+		dest   *int64
+	}{
+		{"select_cnt", &bs.SelectCount},
+		{"insert_cnt", &bs.InsertCount},
+		{"update_cnt", &bs.UpdateCount},
+		{"delete_cnt", &bs.DeleteCount},
+		{"ddl_cnt", &bs.DDLCount},
+		{"other_cnt", &bs.OtherCount},
+	}
+	if _, ok := row["error_cnt"]; ok {
+		bs.ErrorCounts = true
+		ints = append(ints, []struct {
+			column string
+			dest   *int64
+		}{
+			{"panic_cnt", &bs.PanicCount},
+			{"fatal_cnt", &bs.FatalCount},
+			{"error_cnt", &bs.ErrorCount},
+		}...)
+	}
+	if bs.NodeID, err = strconv.Atoi(row["node_id"]); err != nil {
+		return bs, fmt.Errorf("node_id: %v", err)
+	}
+	bs.Port, _ = strconv.Atoi(row["port"])
+	for _, field := range ints {
+		if *field.dest, err = strconv.ParseInt(row[field.column], 10, 64); err != nil {
+			return bs, fmt.Errorf("%s: %v", field.column, err)
+		}
+	}
+	return bs, nil
+}
+
+// ShowPoolBackendStats returns the statements and errors of every node,
+// which requires FeatureBackendStats.
+func (s *SQLClient) ShowPoolBackendStats(ctx context.Context) ([]BackendStats, error) {
+	rows, err := s.query(ctx, SQLShowPoolBackendStats)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]BackendStats, 0, len(rows))
+	for _, row := range rows {
+		bs, err := backendStatsFromRow(row)
+		if err != nil {
+			return nil, parseError(SQLShowPoolBackendStats, err)
+		}
+		stats = append(stats, bs)
+	}
+	return stats, nil
+}
+
+func (s *SQLClient) Close() error {
+	return s.db.Close()
+}
+
+// requireSQL fails with ErrUnsupported unless Options.DSN is set.
+func (c *Client) requireSQL(stmt string) error {
+	if c.sql != nil {
+		return nil
+	}
+	return &CommandError{
+		Command: CommandName(stmt),
+		Reason:  ErrUnsupported,
+		Err:     errors.New("no pgpool DSN configured"),
+	}
+}
+
+// ExecPoolNodes runs SHOW POOL_NODES, which requires Options.DSN.
+func (c *Client) ExecPoolNodes(ctx context.Context) ([]PoolNode, error) {
+	if err := c.requireSQL(SQLShowPoolNodes); err != nil {
+		return nil, err
+	}
+	return c.sql.ShowPoolNodes(ctx)
+}
+
+// ExecPoolCache runs SHOW POOL_CACHE, which requires Options.DSN.
+func (c *Client) ExecPoolCache(ctx context.Context) (PoolCache, error) {
+	if err := c.requireSQL(SQLShowPoolCache); err != nil {
+		return PoolCache{}, err
+	}
+	return c.sql.ShowPoolCache(ctx)
+}
+
+// ExecBackendStats runs SHOW POOL_BACKEND_STATS, which requires
+// Options.DSN and FeatureBackendStats.
+func (c *Client) ExecBackendStats(ctx context.Context) ([]BackendStats, error) {
+	if err := c.requireSQL(SQLShowPoolBackendStats); err != nil {
+		return nil, err
+	}
+	return c.sql.ShowPoolBackendStats(ctx)
+}
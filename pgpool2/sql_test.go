@@ -0,0 +1,213 @@
+package pgpool2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPoolNodeFromRow(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	s := &SQLClient{loc: loc}
+	tests := []struct {
+		name    string
+		row     map[string]string
+		want    PoolNode
+		wantErr bool
+	}{
+		{
+			name: "4.2 standby",
+			row: map[string]string{
+				"node_id": "1", "hostname": "pg1", "port": "5432", "status": "up",
+				"lb_weight": "0.500000", "role": "standby", "select_cnt": "1200",
+				"load_balance_node": "true", "replication_delay": "1024",
+				"replication_state": "streaming", "replication_sync_state": "async",
+				"last_status_change": "2021-02-27 15:10:19",
+			},
+			want: PoolNode{
+				NodeID: 1,
+				NodeInfo: NodeInfo{
+					Hostname: "pg1", Port: 5432, StatusCode: 2, Status: NodeStatusUP2,
+					Weight: 0.5, Role: "standby", ReplicationDelay: 1024,
+					ReplicationState: "streaming", ReplicationSyncState: "async",
+					LastStatusChange:     "2021-02-27 15:10:19",
+					LastStatusChangeTime: time.Date(2021, 2, 27, 15, 10, 19, 0, loc),
+				},
+				SelectCount:     1200,
+				LoadBalanceNode: true,
+			},
+		},
+		{
+			// releases before 4.1 print the status code and the delay
+			// with a unit
+			name: "3.7 primary",
+			row: map[string]string{
+				"node_id": "0", "hostname": "pg0", "port": "5432", "status": "3",
+				"lb_weight": "0.500000", "role": "primary", "select_cnt": "0",
+				"load_balance_node": "false", "replication_delay": "0 second(s)",
+			},
+			want: PoolNode{
+				NodeInfo: NodeInfo{
+					Hostname: "pg0", Port: 5432, StatusCode: 3, Status: NodeStatusDown,
+					Weight: 0.5, Role: "primary",
+				},
+			},
+		},
+		{
+			name: "unknown status",
+			row: map[string]string{
+				"node_id": "0", "hostname": "pg0", "port": "5432", "status": "quarantine",
+				"lb_weight": "0.5", "select_cnt": "0",
+			},
+			want: PoolNode{
+				NodeInfo: NodeInfo{
+					Hostname: "pg0", Port: 5432, StatusCode: -1, Status: NodeStatusUnknown,
+					Weight: 0.5,
+				},
+			},
+		},
+		{
+			name:    "invalid select count",
+			row:     map[string]string{"node_id": "0", "port": "5432", "lb_weight": "0.5", "select_cnt": "many"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.poolNodeFromRow(tt.row)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcInfoFromRow(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	s := &SQLClient{loc: loc}
+	row := map[string]string{
+		"pool_pid": "4321", "start_time": "2021-02-27 15:10:19 (2:55 before process restarting)",
+		"pool_id": "0", "backend_id": "0", "database": "app", "username": "web",
+		"backend_connection_time": "2021-02-27 15:11:00", "client_connection_time": "2021-02-27 15:12:00",
+		"client_disconnection_time": "", "client_idle_duration": "30",
+		"majorversion": "3", "minorversion": "0", "pool_counter": "1", "pool_backendpid": "12345",
+		"pool_connected": "1", "status": "Idle", "load_balance_node": "1",
+		"client_host": "10.0.0.5", "client_port": "51234", "statement": "",
+		"client_connection_count": "3",
+	}
+	want := ProcInfo{
+		PID: 4321, Database: "app", Username: "web", Connected: true,
+		StartTime:             time.Date(2021, 2, 27, 15, 10, 19, 0, loc),
+		BackendConnectionTime: time.Date(2021, 2, 27, 15, 11, 0, 0, loc),
+		ProtocolMajor:         3, PoolCounter: 1, BackendPID: 12345,
+		ClientHost: "10.0.0.5", ClientPort: 51234, ClientConnectionCount: 3,
+		ClientConnectionTime: time.Date(2021, 2, 27, 15, 12, 0, 0, loc),
+		IdleDuration:         30 * time.Second, Status: "Idle", LoadBalanceNode: true,
+	}
+	got, err := s.procInfoFromRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	row["pool_backendpid"] = "pid"
+	if _, err := s.procInfoFromRow(row); err == nil {
+		t.Error("invalid pool_backendpid was accepted")
+	}
+}
+
+func TestBackendStatsFromRow(t *testing.T) {
+	counts := map[string]string{
+		"node_id": "1", "hostname": "pg1", "port": "5432", "status": "up", "role": "standby",
+		"select_cnt": "1200", "insert_cnt": "10", "update_cnt": "20", "delete_cnt": "3",
+		"ddl_cnt": "1", "other_cnt": "40",
+	}
+	withErrors := map[string]string{"panic_cnt": "0", "fatal_cnt": "2", "error_cnt": "7"}
+	for column, value := range counts {
+		withErrors[column] = value
+	}
+	invalid := map[string]string{"node_id": "1", "select_cnt": ""}
+
+	tests := []struct {
+		name    string
+		row     map[string]string
+		want    BackendStats
+		wantErr bool
+	}{
+		{
+			name: "without error counts",
+			row:  counts,
+			want: BackendStats{
+				NodeID: 1, Hostname: "pg1", Port: 5432,
+				SelectCount: 1200, InsertCount: 10, UpdateCount: 20, DeleteCount: 3, DDLCount: 1, OtherCount: 40,
+			},
+		},
+		{
+			name: "with error counts",
+			row:  withErrors,
+			want: BackendStats{
+				NodeID: 1, Hostname: "pg1", Port: 5432,
+				SelectCount: 1200, InsertCount: 10, UpdateCount: 20, DeleteCount: 3, DDLCount: 1, OtherCount: 40,
+				ErrorCounts: true, FatalCount: 2, ErrorCount: 7,
+			},
+		},
+		{name: "empty count", row: invalid, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := backendStatsFromRow(tt.row)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolCacheFromRow(t *testing.T) {
+	got, err := poolCacheFromRow(map[string]string{
+		"num_cache_hits": "300", "num_selects": "1200", "cache_hit_ratio": "0.25",
+		"num_hash_entries": "2048", "used_hash_entries": "64", "num_cache_entries": "64",
+		"used_cache_entries_size": "131072", "free_cache_entries_size": "4063232",
+		"fragment_cache_entries_size": "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PoolCache{
+		CacheHits: 300, Selects: 1200, CacheHitRatio: 0.25,
+		HashEntries: 2048, UsedHashEntries: 64, CacheEntries: 64,
+		UsedCacheEntriesSize: 131072, FreeCacheEntriesSize: 4063232,
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// the query cache is disabled
+	got, err = poolCacheFromRow(map[string]string{"num_cache_hits": "", "cache_hit_ratio": ""})
+	if err != nil || got != (PoolCache{}) {
+		t.Errorf("got %+v, %v for a disabled query cache", got, err)
+	}
+	if _, err := poolCacheFromRow(map[string]string{"num_selects": "x"}); err == nil {
+		t.Error("invalid num_selects was accepted")
+	}
+}
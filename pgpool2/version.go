@@ -0,0 +1,186 @@
+package pgpool2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNativeVersion = errors.New("the native PCP backend cannot detect the pgpool version")
+
+// VersionDetector is implemented by sources detecting the pgpool release
+// themselves. ServerVersion returns cached results, so its errors do not
+// tell whether a command ran.
+type VersionDetector interface {
+	// ObserveVersionDetection registers fn, called with the result of
+	// every detection attempt
+	ObserveVersionDetection(fn func(err error))
+}
+
+var _ VersionDetector = (*Client)(nil)
+
+// versionRetryInterval is how long a failed version detection is
+// returned before it is attempted again.
+const versionRetryInterval = time.Minute
+
+// Features which are only available in some pgpool releases.
+const (
+	// FeatureHealthCheckStats is pcp_health_check_stats
+	FeatureHealthCheckStats = "health_check_stats"
+	// FeatureAllNodeInfo is pcp_node_info returning every node at once
+	FeatureAllNodeInfo = "all_node_info"
+	// FeatureReplicationState are the replication state fields of
+	// pcp_node_info
+	FeatureReplicationState = "replication_state"
+	// FeatureWatchdogMembership are the cluster membership fields of
+	// pcp_watchdog_info
+	FeatureWatchdogMembership = "watchdog_membership"
+	// FeatureBackendStats is SHOW POOL_BACKEND_STATS
+	FeatureBackendStats = "backend_stats"
+)
+
+var (
+	versionRegExp = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+	// featureSince maps every feature to the first release supporting it
+	featureSince = map[string]Version{
+		FeatureHealthCheckStats:   {Major: 4, Minor: 1},
+		FeatureAllNodeInfo:        {Major: 4, Minor: 1},
+		FeatureReplicationState:   {Major: 4, Minor: 1},
+		FeatureWatchdogMembership: {Major: 4, Minor: 3},
+		FeatureBackendStats:       {Major: 4, Minor: 1},
+	}
+)
+
+// Version is a pgpool-II release, the zero value means unknown.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// ParseVersion extracts the first version number from text like
+// "pcp_node_count (pgpool-II) 4.1.2" or "4.2.0 (chichiriboshi)".
+func ParseVersion(text string) (Version, error) {
+	match := versionRegExp.FindStringSubmatch(text)
+	if match == nil {
+		return Version{}, fmt.Errorf("no version number in %q", text)
+	}
+	var v Version
+	v.Major, _ = strconv.Atoi(match[1])
+	v.Minor, _ = strconv.Atoi(match[2])
+	if len(match[3]) != 0 {
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v, nil
+}
+
+func (v Version) IsZero() bool {
+	return v == Version{}
+}
+
+func (v Version) String() string {
+	if v.IsZero() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the release o or a later one.
+func (v Version) AtLeast(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major > o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor > o.Minor
+	}
+	return v.Patch >= o.Patch
+}
+
+// Supports reports whether the release has feature. Features of an
+// unknown release are assumed to be supported so that a failed version
+// detection does not silently disable collectors.
+func (v Version) Supports(feature string) bool {
+	since, ok := featureSince[feature]
+	if !ok {
+		return false
+	}
+	return v.IsZero() || v.AtLeast(since)
+}
+
+// Features returns the names of all known features.
+func Features() []string {
+	features := make([]string, 0, len(featureSince))
+	for feature := range featureSince {
+		features = append(features, feature)
+	}
+	return features
+}
+
+// detectVersion runs SHOW POOL_VERSION if a DSN is configured, and asks
+// the pcp_* binaries for their version otherwise; they are shipped with
+// pgpool and expected to match the server. The PCP protocol itself does
+// not report the version, so the native backend relies on Options.Version
+// or Options.DSN and runs with an unknown version otherwise.
+func (c *Client) detectVersion(ctx context.Context) (Version, error) {
+	if c.sql != nil {
+		return c.sql.ShowPoolVersion(ctx)
+	}
+	if c.options.Backend == BackendNative {
+		return Version{}, errNativeVersion
+	}
+	ctx, cancel := c.commandContext(ctx)
+	defer cancel()
+	// the version is printed before any connection is attempted
+	out, err := exec.CommandContext(ctx, PCPNodeCount, "--version").CombinedOutput()
+	if err != nil {
+		return Version{}, commandError(ctx, PCPNodeCount, err, classifyExecError(err, ""), strings.TrimSpace(string(out)))
+	}
+	v, err := ParseVersion(string(out))
+	if err != nil {
+		return Version{}, parseError(PCPNodeCount, err)
+	}
+	return v, nil
+}
+
+// ServerVersion returns the pgpool release, detected once and cached. It
+// returns the zero Version and an error as long as detection fails; a
+// failure is cached for versionRetryInterval so that the collectors of a
+// scrape do not all detect again.
+func (c *Client) ServerVersion(ctx context.Context) (Version, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if !c.version.IsZero() {
+		return c.version, nil
+	}
+	if c.versionErr != nil && time.Now().Before(c.versionRetry) {
+		return Version{}, c.versionErr
+	}
+	v, err := c.detectVersion(ctx)
+	if c.versionObserver != nil {
+		c.versionObserver(err)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.versionErr = err
+			c.versionRetry = time.Now().Add(versionRetryInterval)
+		}
+		return Version{}, err
+	}
+	c.version = v
+	c.versionErr = nil
+	return v, nil
+}
+
+// ObserveVersionDetection registers fn, called with the result of every
+// detection attempt of ServerVersion.
+func (c *Client) ObserveVersionDetection(fn func(err error)) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	c.versionObserver = fn
+}
@@ -0,0 +1,55 @@
+package pgpool2
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestServerVersionCachesFailure(t *testing.T) {
+	// the pcp_* binaries are not installed in the test environment
+	client := &Client{options: Options{Backend: BackendExec}}
+	var attempts int
+	client.ObserveVersionDetection(func(err error) { attempts++ })
+	_, first := client.ServerVersion(context.Background())
+	if first == nil {
+		t.Skip("pcp_node_count is installed")
+	}
+	_, second := client.ServerVersion(context.Background())
+	if second != first {
+		t.Errorf("got %v, want the cached error %v", second, first)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d observed detections, want 1 as the second call was cached", attempts)
+	}
+	client.versionRetry = time.Now().Add(-time.Second)
+	if _, third := client.ServerVersion(context.Background()); third == first {
+		t.Error("failed detection was not attempted again after versionRetryInterval")
+	}
+	if attempts != 2 {
+		t.Errorf("got %d observed detections, want 2", attempts)
+	}
+}
+
+func TestNativeRunsWithUnknownVersion(t *testing.T) {
+	client, err := NewClient(Options{
+		Backend:  BackendNative,
+		Hostname: "127.0.0.1",
+		Port:     9898,
+		Username: "pcpadmin",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("native backend without version or DSN: %v", err)
+	}
+	defer client.Clean()
+	version, err := client.ServerVersion(context.Background())
+	if err == nil || !version.IsZero() {
+		t.Fatalf("got version %s, %v, want an unknown version", version, err)
+	}
+	for _, feature := range Features() {
+		if !version.Supports(feature) {
+			t.Errorf("unknown version does not support %s", feature)
+		}
+	}
+}